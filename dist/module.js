@@ -55,9 +55,10 @@ System.register(['./datasource', './query_ctrl'], function (_export, _context) {
           this.current.jsonData.sftpPort = this.current.jsonData.sftpPort || '';
           this.current.jsonData.sftpUser = this.current.jsonData.sftpUser || '';
           this.current.jsonData.sftpIgnoreHostKey = this.current.jsonData.sftpIgnoreHostKey || false;
-          this.current.jsonData.sftpWorkingDir = this.current.jsonData.sftpWorkingDir || '';
+          this.current.jsonData.sftpHostKey = this.current.jsonData.sftpHostKey || '';
           this.current.secureJsonData = this.current.secureJsonData || {};
           this.current.secureJsonData.sftpPassword = this.current.secureJsonData.sftpPassword || null;
+          this.current.secureJsonData.sftpPrivateKey = this.current.secureJsonData.sftpPrivateKey || null;
           this.current.jsonData.columns = this.current.jsonData.columns || [];
 
           this.onPasswordReset = function (event) {
@@ -72,6 +73,13 @@ System.register(['./datasource', './query_ctrl'], function (_export, _context) {
             _this.current.secureJsonData = _this.current.secureJsonData || {};
             _this.current.secureJsonData['sftpPassword'] = event.currentTarget.value;
           };
+
+          this.onPrivateKeyReset = function (event) {
+            event.preventDefault();
+            _this.current.secureJsonFields['sftpPrivateKey'] = false;
+            _this.current.secureJsonData = _this.current.secureJsonData || {};
+            _this.current.secureJsonData['sftpPrivateKey'] = '';
+          };
         }
 
         _createClass(FileConfigCtrl, [{
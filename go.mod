@@ -1,6 +1,6 @@
 module github.com/paveldanilin/grafana-csv-plugin
 
-go 1.14
+go 1.16
 
 require (
 	github.com/antonmedv/expr v1.8.6
@@ -10,7 +10,10 @@ require (
 	github.com/hashicorp/go-plugin v1.2.2
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/pkg/sftp v1.11.0
-	github.com/stretchr/testify v1.5.1
-	golang.org/x/crypto v0.0.0-20190820162420-60c769a6c586
+	github.com/stretchr/testify v1.7.0
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 	golang.org/x/net v0.0.0-20200425230154-ff2c4b7c35a0
+	golang.org/x/text v0.3.2
 )
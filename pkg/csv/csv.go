@@ -1,27 +1,158 @@
 package csv
 
 import (
+	"bufio"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
-	"github.com/paveldanilin/grafana-csv-plugin/pkg/util"
-	"os"
+	"fmt"
+	"golang.org/x/net/html/charset"
+	"hash"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
 )
 
 type FileDescriptor struct {
 	Filename string
+	// Where the data is read from, the local file Filename if nil
+	Source Source
 	fileSize int64
 	fileModTime int64
+	warnings *warningCollector
+	// Charset of the data (e.g. `windows-1252`, `iso-8859-1`), UTF-8 if empty
+	Encoding string
+	// Guess the charset from the beginning of the data if Encoding is empty, see decodeContent
+	DetectEncoding bool
 	Delimiter rune
 	Comment rune
 	TrimLeadingSpace bool
 	FieldsPerRecord int
+	// Rows may omit trailing fields (FieldsPerRecord is ignored), an omitted field is stored as NULL whatever
+	// the defaults are, while an empty one follows the usual rules (e.g. it is an empty string in a TEXT column).
+	// A row with more fields than the header fails the load
+	AllowShortRows bool
+	// The row right below the header holds units of measure (m/s, kg), it is skipped and kept in Column.Unit
+	UnitsRow bool
+	// The row right below the header (below the units row if any) declares column types (see ParseColumnType)
+	FirstRowIsTypes bool
+	// Fail the load when a value can not be represented exactly in its column type (e.g. 3.14 in an integer column),
+	// instead of silently storing the raw string
+	Strict bool
+	// Detect column types by the first rows rather than by the first one, and fail the load if
+	// the values of a column without an explicit type have different types
+	StrictSchema bool
+	// The load fails if the header has other columns, e.g. after an upstream schema change
+	ExpectedHeader []string
+	// Columns of ExpectedHeader may come in any order
+	IgnoreHeaderOrder bool
+	// Regular expression of the first line to parse (the header), the lines before it are discarded,
+	// e.g. a preamble of a log file
+	StartPattern string
+	// The first line is data, the columns (defined or provided by SchemaProvider) are taken in order.
+	// Without them the columns are named col1..colN
+	NoHeader bool
+	// Supplies Columns by SchemaKey if they are not defined
+	SchemaProvider SchemaProvider
+	SchemaKey      string
+	// Types of some columns by name, they take the place of detection (e.g. text for zip codes), the other columns
+	// keep the defined or detected type. Every name must be a column of the header
+	ColumnTypeOverrides map[string]ColumnType
+	// Sidecar file of ColumnTypeOverrides, one `name=type` per line, blank and `#` lines are skipped.
+	// An override of ColumnTypeOverrides wins over the file
+	TypesFile string
+	// Load every raw line into the only TEXT column, delimiters, quotes and comments are not parsed and
+	// there is no header. Columns, UnitsRow, FirstRowIsTypes and type overrides are ignored
+	SingleColumn bool
+	// Name of the SingleColumn column, `line` if not set
+	SingleColumnName string
+	// The load fails if the header has more columns, 2000 (the SQLite limit) if not set
+	MaxColumns int
+	// Fail the load on a longer line (e.g. of a file without line terminators) instead of buffering it whole.
+	// A quoted multi-line value counts by its lines. No limit if zero
+	MaxLineBytes int
+	// Count of trailing rows (e.g. totals) which are neither used for type detection nor inserted
+	SkipFooterRows int
+	// Trim leading and trailing whitespace of every value (unlike TrimLeadingSpace, which is applied by the CSV parser)
+	TrimSpace bool
+	// Values stored as NULL, e.g. NULL, N/A or -. They are matched exactly after trimming, see also Column.NullValues
+	NullValues []string
+	// Store blank values as NULL: empty ones in every column, whitespace-only ones in non TEXT columns.
+	// Checked after trimming and NullValues, it takes precedence over DefaultDate and TypeDefaults
+	NullifyBlank bool
+	// NulBytesStrip (if empty) or NulBytesReject, NUL bytes are never stored
+	NulBytes string
+	// How empty values of each type are stored: TypeDefaultNull, TypeDefaultZero or a literal value.
+	// The same default is declared in the table. Types missing in the map keep the built-in behavior.
+	TypeDefaults map[ColumnType]string
+	// Count of the first raw bytes of the source (before decoding) kept in LoadStats.RawPrefix, e.g. to look for a BOM
+	// or a wrong encoding. Capped at 64KB, nothing is kept if zero
+	CaptureRawBytes int
+	// SHA256 (hex) of the source, computed while reading. On mismatch nothing is loaded. Empty skips the check
+	ExpectedChecksum string
+	// Count of rows the table must have after the load (e.g. from a manifest), otherwise nothing is loaded.
+	// Not checked if nil
+	ExpectedRows *int
+	// Remove duplicate rows (all values are equal) after loading, only the first occurrence is kept.
+	// Deduplication runs inside SQLite (GROUP BY over all columns), so it does not hold rows in Go memory,
+	// but it needs temp storage proportional to the table and noticeably slows down the load of large files.
+	Distinct bool
+	// Build the reloaded table under a temporary name and swap it in, so queries keep seeing the old table
+	// until the new one is complete. Needs memory for both tables during the reload
+	ReplaceOnReload bool
+	// Count of parsed records buffered while the inserts lag behind, 1000 if not set
+	BufferSize int
+	// Extra go-sqlite3 DSN parameters of the dataset DB (see DatasetCache), e.g. _foreign_keys=on.
+	// mode and cache are required by the loader and can not be set.
+	// _loc=auto reads dates without a zone back in the local time zone instead of UTC
+	DSNParams map[string]string
+	// Add a date column holding the time of the load, the same for all rows. It is not added by Pivot
+	AddLoadTimestamp bool
+	// Name of the AddLoadTimestamp column, `_loaded_at` if not set
+	LoadTimestampColumn string
+	// Reshape each CSV row into one row per value column before inserting
+	Unpivot *Unpivot
+	// Turn key-attribute-value rows into a wide table with one row per key and one column per attribute
+	Pivot *Pivot
+	// Statements (e.g. CREATE VIEW, CREATE INDEX) executed in order once the table is loaded, in the same transaction,
+	// so a failed one rolls back the whole load. They run on every reload, hence objects other than the table itself
+	// should be created with IF NOT EXISTS
+	PostLoadSQL []string
+	// Run ANALYZE of the loaded table (after PostLoadSQL), so the query planner knows how selective its indexes are.
+	// It adds to the load time, by default it runs only if the table has an index (e.g. created by PostLoadSQL)
+	Analyze *bool
 	// User defined or auto detected info about columns
 	Columns []Column
 }
 
+// Either encoding/csv reader or lineReader
+type recordReader interface {
+	Read() ([]string, error)
+}
+
 type reader struct {
-	file *os.File
-	csv  *csv.Reader
+	file io.ReadCloser
+	csv  recordReader
+	// The data stream, it feeds checksum if any
+	data     io.Reader
+	checksum hash.Hash
+	// The first bytes of the data if FileDescriptor.CaptureRawBytes is set
+	rawPrefix *prefixWriter
+	// Count of trailing empty records skipped by read()
+	skipped int
+	// Empty records held back till a non empty one shows they are not trailing.
+	// Only their counts are kept, so a long blank block does not grow the memory
+	held []emptyRun
+	// The non empty record following the held ones
+	pending []string
+	// Records read ahead by peek()
+	buffer [][]string
+	// Count of trailing records (e.g. a totals row) which are never returned
+	footerSize int
+	// Records read ahead to recognize the footer
+	tail [][]string
 }
 
 func newCsvReader(descriptor *FileDescriptor) (*reader, error) {
@@ -29,27 +160,336 @@ func newCsvReader(descriptor *FileDescriptor) (*reader, error) {
 		return nil, errors.New("file descriptor is missed")
 	}
 
-	descriptor.fileSize, descriptor.fileModTime = util.FileStat(descriptor.Filename)
+	if err := validateDescriptor(descriptor); err != nil {
+		return nil, err
+	}
+
+	source := resolveSource(descriptor)
+	descriptor.fileSize, descriptor.fileModTime, _ = source.Stat()
 
-	file, err := os.Open(descriptor.Filename)
+	file, err := source.Open()
 	if err != nil {
 		return nil, err
 	}
 
-	csvReader := csv.NewReader(file)
-	csvReader.Comma = descriptor.Delimiter
-	csvReader.Comment = descriptor.Comment
-	csvReader.TrimLeadingSpace = descriptor.TrimLeadingSpace
-	csvReader.FieldsPerRecord = descriptor.FieldsPerRecord
+	var data io.Reader = file
+	var rawPrefix *prefixWriter
+	if descriptor.CaptureRawBytes > 0 {
+		rawPrefix = &prefixWriter{max: descriptor.CaptureRawBytes}
+		if rawPrefix.max > maxRawPrefixBytes {
+			rawPrefix.max = maxRawPrefixBytes
+		}
+		data = io.TeeReader(data, rawPrefix)
+	}
+	var checksum hash.Hash
+	if descriptor.ExpectedChecksum != "" {
+		checksum = sha256.New()
+		data = io.TeeReader(data, checksum)
+	}
+
+	content, err := decodeContent(data, descriptor)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if descriptor.MaxLineBytes > 0 {
+		content = &lineLimitReader{reader: content, max: descriptor.MaxLineBytes}
+	}
+	if descriptor.StartPattern != "" {
+		content, err = skipUntil(content, regexp.MustCompile(descriptor.StartPattern))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	var records recordReader
+	if descriptor.SingleColumn {
+		records = &lineReader{reader: bufio.NewReader(content)}
+	} else {
+		csvReader := csv.NewReader(content)
+		csvReader.Comma = descriptor.Delimiter
+		csvReader.Comment = descriptor.Comment
+		csvReader.TrimLeadingSpace = descriptor.TrimLeadingSpace
+		csvReader.FieldsPerRecord = descriptor.FieldsPerRecord
+		if descriptor.AllowShortRows {
+			csvReader.FieldsPerRecord = -1
+		}
+		records = csvReader
+	}
 
 	return &reader{
-		file: file,
-		csv:  csvReader,
+		file:       file,
+		csv:        records,
+		data:       data,
+		checksum:   checksum,
+		rawPrefix:  rawPrefix,
+		footerSize: descriptor.SkipFooterRows,
 	}, nil
 }
 
+// Upper limit of FileDescriptor.CaptureRawBytes
+const maxRawPrefixBytes = 64 * 1024
+
+// Keeps the first max bytes written to it, the rest is discarded
+type prefixWriter struct {
+	max    int
+	prefix []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if free := w.max - len(w.prefix); free > 0 {
+		if len(p) < free {
+			free = len(p)
+		}
+		w.prefix = append(w.prefix, p[:free]...)
+	}
+	return len(p), nil
+}
+
+// The captured bytes, nil if they are not captured
+func (r *reader) rawBytes() []byte {
+	if r.rawPrefix == nil {
+		return nil
+	}
+	return r.rawPrefix.prefix
+}
+
+// Reads the rest of the data (e.g. a trailing comment) and compares its checksum with the expected one
+func (r *reader) verifyChecksum(expected string) error {
+	if r.checksum == nil {
+		return nil
+	}
+	if _, err := io.Copy(ioutil.Discard, r.data); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(r.checksum.Sum(nil))
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return errors.New(fmt.Sprintf("checksum mismatch: expected `%s`, got `%s`", expected, actual))
+	}
+	return nil
+}
+
+// Discards the lines before the first one matching the pattern
+func skipUntil(data io.Reader, pattern *regexp.Regexp) (io.Reader, error) {
+	lines := bufio.NewReader(data)
+	for {
+		line, err := lines.ReadString('\n')
+		if pattern.MatchString(strings.TrimRight(line, "\r\n")) {
+			return io.MultiReader(strings.NewReader(line), lines), nil
+		}
+		if err == io.EOF {
+			return nil, errors.New(fmt.Sprintf("no line matches the start pattern `%s`", pattern.String()))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Fails once a line is longer than max bytes, line terminators are not counted
+type lineLimitReader struct {
+	reader io.Reader
+	max    int
+	// Bytes of the current line read so far
+	length int
+	line   int
+}
+
+func (r *lineLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	for _, b := range p[:n] {
+		if b == '\n' {
+			r.length = 0
+			r.line++
+			continue
+		}
+		if b != '\r' {
+			r.length++
+		}
+		if r.length > r.max {
+			return 0, errors.New(fmt.Sprintf("line %d exceeds %d bytes, is the line terminator missing?", r.line+1, r.max))
+		}
+	}
+	return n, err
+}
+
+// Returns every raw line as a record of one field, see FileDescriptor.SingleColumn
+type lineReader struct {
+	reader *bufio.Reader
+}
+
+// Empty lines are skipped, as encoding/csv does
+func (r *lineReader) Read() ([]string, error) {
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return []string{line}, nil
+		}
+	}
+}
+
+func singleColumnName(descriptor *FileDescriptor) string {
+	if descriptor.SingleColumnName != "" {
+		return descriptor.SingleColumnName
+	}
+	return defaultSingleColumnName
+}
+
+const defaultSingleColumnName = "line"
+
+const defaultLoadTimestampColumn = "_loaded_at"
+
+func loadTimestampColumnName(descriptor *FileDescriptor) string {
+	if descriptor.LoadTimestampColumn != "" {
+		return descriptor.LoadTimestampColumn
+	}
+	return defaultLoadTimestampColumn
+}
+
+// The CSV quote character is always `"`
+const quoteChar = '"'
+
+// Rejects combinations of special characters which encoding/csv would silently parse into garbage
+func validateDescriptor(descriptor *FileDescriptor) error {
+	if descriptor.StartPattern != "" {
+		if _, err := regexp.Compile(descriptor.StartPattern); err != nil {
+			return errors.New(fmt.Sprintf("invalid start pattern: %s", err.Error()))
+		}
+	}
+	if descriptor.Encoding != "" {
+		if e, _ := charset.Lookup(descriptor.Encoding); e == nil {
+			return errors.New(fmt.Sprintf("unknown encoding `%s`", descriptor.Encoding))
+		}
+	}
+	if descriptor.NulBytes != "" && descriptor.NulBytes != NulBytesStrip && descriptor.NulBytes != NulBytesReject {
+		return errors.New(fmt.Sprintf("unknown NUL bytes handling `%s`, expected one of: strip, reject", descriptor.NulBytes))
+	}
+	for i, statement := range descriptor.PostLoadSQL {
+		if strings.TrimSpace(statement) == "" {
+			return errors.New(fmt.Sprintf("post-load SQL statement %d is empty", i+1))
+		}
+	}
+	if descriptor.Delimiter == quoteChar {
+		return errors.New(fmt.Sprintf("delimiter `%c` can not be the quote character", descriptor.Delimiter))
+	}
+	if descriptor.Comment != 0 && descriptor.Comment == descriptor.Delimiter {
+		return errors.New(fmt.Sprintf("comment `%c` can not be the same as the delimiter", descriptor.Comment))
+	}
+	if descriptor.Comment == quoteChar {
+		return errors.New(fmt.Sprintf("comment `%c` can not be the quote character", descriptor.Comment))
+	}
+	return nil
+}
+
 func (r *reader) close() {
 	r.file.Close()
 	r.file = nil
 	r.csv = nil
 }
+
+// Reads the next data record, skipping trailing empty ones (e.g. a phantom record produced by a last line with only delimiters)
+func (r *reader) read() ([]string, error) {
+	if len(r.buffer) > 0 {
+		record := r.buffer[0]
+		r.buffer = r.buffer[1:]
+		return record, nil
+	}
+	return r.next()
+}
+
+// Returns the next data record unless it belongs to the footer, i.e. it is one of the last footerSize records
+func (r *reader) next() ([]string, error) {
+	for len(r.tail) <= r.footerSize {
+		record, err := r.nextRecord()
+		if err != nil {
+			// At EOF the tail holds the footer
+			return nil, err
+		}
+		r.tail = append(r.tail, record)
+	}
+	record := r.tail[0]
+	r.tail = r.tail[1:]
+	return record, nil
+}
+
+// Consecutive empty records of the same field count
+type emptyRun struct {
+	fields int
+	count  int
+}
+
+// Empty records are returned only once a non empty record follows them, the ones before EOF are dropped.
+// A returned empty record has only empty values, even if it was made of spaces
+func (r *reader) nextRecord() ([]string, error) {
+	if len(r.held) > 0 {
+		record := make([]string, r.held[0].fields)
+		if r.held[0].count--; r.held[0].count == 0 {
+			r.held = r.held[1:]
+		}
+		return record, nil
+	}
+	if r.pending != nil {
+		record := r.pending
+		r.pending = nil
+		return record, nil
+	}
+	for {
+		record, err := r.csv.Read()
+		if err == io.EOF {
+			for _, run := range r.held {
+				r.skipped += run.count
+			}
+			r.held = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isEmptyRecord(record) {
+			if last := len(r.held) - 1; last >= 0 && r.held[last].fields == len(record) {
+				r.held[last].count++
+			} else {
+				r.held = append(r.held, emptyRun{fields: len(record), count: 1})
+			}
+			continue
+		}
+		if len(r.held) == 0 {
+			return record, nil
+		}
+		r.pending = record
+		return r.nextRecord()
+	}
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, value := range record {
+		if len(strings.TrimSpace(value)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Puts the record back, read() returns it first
+func (r *reader) unread(record []string) {
+	r.buffer = append([][]string{record}, r.buffer...)
+}
+
+// Reads ahead up to n data records, read() returns them afterwards
+func (r *reader) peek(n int) ([][]string, error) {
+	for len(r.buffer) < n {
+		record, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.buffer = append(r.buffer, record)
+	}
+	return r.buffer, nil
+}
@@ -0,0 +1,212 @@
+package csv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDescriptor(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter rune
+		comment   rune
+		err       string
+	}{
+		{"valid", ',', '#', ""},
+		{"no_comment", ';', 0, ""},
+		{"delimiter_is_quote", '"', '#', "delimiter `\"` can not be the quote character"},
+		{"delimiter_is_comment", ';', ';', "comment `;` can not be the same as the delimiter"},
+		{"comment_is_quote", ',', '"', "comment `\"` can not be the quote character"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateDescriptor(&FileDescriptor{Delimiter: test.delimiter, Comment: test.comment})
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestNewCsvReader_InvalidDescriptor(t *testing.T) {
+	descriptor := newTestDescriptor(writeTestCSV(t, "a;b\n1;2\n"))
+	descriptor.Delimiter = '#'
+
+	_, err := newCsvReader(descriptor)
+	assert.EqualError(t, err, "comment `#` can not be the same as the delimiter")
+}
+
+func TestLoadCSV_StartPattern(t *testing.T) {
+	sqlite := newTestDB(t)
+	content := "Report generated at 2020-05-01\nhost: web-1, web-2\n\n=====\nname,qty\nfirst,1\nsecond,2\n"
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.StartPattern = `^name,`
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+	assert.Equal(t, []string{"name", "qty"}, getColumnNames(descriptor.Columns))
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.StartPattern = `^id,`
+	_, err := sqlite.LoadCSV("missing", descriptor)
+	assert.EqualError(t, err, "no line matches the start pattern `^id,`")
+}
+
+func TestValidateDescriptor_StartPattern(t *testing.T) {
+	err := validateDescriptor(&FileDescriptor{Delimiter: ',', StartPattern: "(name"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid start pattern")
+}
+
+func TestLoadCSV_EmptyRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		rows    int
+	}{
+		{"without_newline", "id,name\n1,a\n2,b", 2},
+		{"with_newline", "id,name\n1,a\n2,b\n", 2},
+		{"with_crlf", "id,name\r\n1,a\r\n2,b\r\n", 2},
+		{"with_blank_lines", "id,name\n1,a\n2,b\n\n\n", 2},
+		{"with_phantom_record", "id,name\n1,a\n2,b\n,\n", 2},
+		{"with_phantom_spaces", "id,name\n1,a\n2,b\n , \n", 2},
+		// Only the trailing ones are skipped
+		{"in_the_middle", "id,name\n1,a\n,\n , \n2,b\n,\n", 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlite := newTestDB(t)
+			mustLoadCSV(t, sqlite, "data", newTestDescriptor(writeTestCSV(t, test.content)))
+			assert.Equal(t, test.rows, countRows(t, sqlite, "data"))
+		})
+	}
+}
+
+func TestReader_HeldEmptyRecords(t *testing.T) {
+	content := "id,name\n1,a\n" + strings.Repeat(",\n", 10000) + "2,b\n,\n,\n"
+	reader, err := newCsvReader(newTestDescriptor(writeTestCSV(t, content)))
+	require.NoError(t, err)
+	defer reader.close()
+
+	for _, expected := range [][]string{{"id", "name"}, {"1", "a"}, {"", ""}} {
+		record, err := reader.read()
+		require.NoError(t, err)
+		assert.Equal(t, expected, record)
+	}
+	// The blank block is held as a count, not as records
+	require.Len(t, reader.held, 1)
+	assert.Equal(t, 9999, reader.held[0].count)
+
+	records := 0
+	for {
+		if _, err := reader.read(); err != nil {
+			break
+		}
+		records++
+	}
+	assert.Equal(t, 10000, records)
+	assert.Equal(t, 2, reader.skipped)
+}
+
+func TestLoadCSV_EmptyFirstDataRow(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "id,price\n,\n1,1.5\n"))
+	descriptor.StrictSchema = true
+	mustLoadCSV(t, sqlite, "prices", descriptor)
+	assert.Equal(t, []ColumnType{ColumnTypeInteger, ColumnTypeReal}, []ColumnType{descriptor.Columns[0].Type, descriptor.Columns[1].Type})
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "id,price\n,\n1,1.5\n"))
+	mustLoadCSV(t, sqlite, "prices_default", descriptor)
+	assert.Equal(t, []ColumnType{ColumnTypeInteger, ColumnTypeReal}, []ColumnType{descriptor.Columns[0].Type, descriptor.Columns[1].Type})
+	assert.Equal(t, 2, countRows(t, sqlite, "prices_default"))
+}
+
+func TestLoadCSV_SkipFooterRows(t *testing.T) {
+	content := "region,orders,revenue\nnorth,10,1500\nsouth,12,1700\nTotal,,3200.50\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.StrictSchema = true
+	_, err := sqlite.LoadCSV("sales", descriptor)
+	assert.EqualError(t, err, "ambiguous type of column `revenue`: `1500` is integer, but `3200.50` is real")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.StrictSchema = true
+	descriptor.SkipFooterRows = 1
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+	assert.Equal(t, []Column{
+		{Type: ColumnTypeText, Name: "region"},
+		{Type: ColumnTypeInteger, Name: "orders"},
+		{Type: ColumnTypeInteger, Name: "revenue"},
+	}, descriptor.Columns)
+
+	var totals int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM sales WHERE region = 'Total'").Scan(&totals))
+	assert.Equal(t, 0, totals)
+}
+
+func TestLoadCSV_SingleColumn(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "2020-01-01 10:00:00,INFO,started\r\n# not a comment, \"not quoted\n\nlast,line"))
+	descriptor.SingleColumn = true
+	descriptor.SingleColumnName = "raw"
+	stats := mustLoadCSV(t, sqlite, "log", descriptor)
+	assert.Equal(t, 3, stats.Rows)
+
+	rows, err := sqlite.db.Query("SELECT raw FROM log ORDER BY rowid")
+	require.NoError(t, err)
+	defer rows.Close()
+	lines := make([]string, 0)
+	for rows.Next() {
+		var line string
+		require.NoError(t, rows.Scan(&line))
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{"2020-01-01 10:00:00,INFO,started", "# not a comment, \"not quoted", "last,line"}, lines)
+}
+
+func TestLoadCSV_MaxLineBytes(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "line\r\n"+strings.Repeat("x", 100)))
+	descriptor.SingleColumn = true
+	descriptor.MaxLineBytes = 64
+	_, err := sqlite.LoadCSV("log", descriptor)
+	assert.EqualError(t, err, "line 2 exceeds 64 bytes, is the line terminator missing?")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "a,b\r\n1,"+strings.Repeat("x", 60)+"\r\n"))
+	descriptor.MaxLineBytes = 64
+	stats := mustLoadCSV(t, sqlite, "data", descriptor)
+	assert.Equal(t, 1, stats.Rows)
+}
+
+func TestLoadCSV_ExpectedChecksum(t *testing.T) {
+	content := "name,qty\nfirst,1\nsecond,2\n"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.ExpectedChecksum = strings.ToUpper(checksum)
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+
+	// Nothing is loaded on mismatch
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.ExpectedChecksum = strings.Repeat("0", len(checksum))
+	_, err := sqlite.LoadCSV("corrupted", descriptor)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	exists, err := sqlite.ifTableExists("corrupted")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, sqlite.getMetaCsv("corrupted"))
+}
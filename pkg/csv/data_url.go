@@ -0,0 +1,71 @@
+package csv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// dataURLSource reads inline data of a data URL, e.g. data:text/csv;base64,bmFtZQpmaXJzdAo=
+type dataURLSource struct {
+	url string
+}
+
+// IsDataURL reports whether the filename is a data URL
+func IsDataURL(filename string) bool {
+	return strings.HasPrefix(strings.ToLower(filename), "data:")
+}
+
+func (s *dataURLSource) Open() (io.ReadCloser, error) {
+	data, err := decodeDataURL(s.url)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// The modification time is a hash of the URL, so any change of the data is noticed
+func (s *dataURLSource) Stat() (int64, int64, error) {
+	data, err := decodeDataURL(s.url)
+	if err != nil {
+		return 0, 0, err
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(s.url))
+	return int64(len(data)), int64(hash.Sum64() >> 1), nil
+}
+
+// Decodes data:[<media type>][;base64],<data>, the data is either base64 or URL encoded
+func decodeDataURL(dataURL string) ([]byte, error) {
+	if !IsDataURL(dataURL) {
+		return nil, errors.New("malformed data URL: the scheme is not `data:`")
+	}
+	comma := strings.Index(dataURL, ",")
+	if comma == -1 {
+		return nil, errors.New("malformed data URL: missing `,` before the data")
+	}
+	header, payload := dataURL[len("data:"):comma], dataURL[comma+1:]
+
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			// Padding is often omitted
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("malformed data URL: %s", err.Error()))
+		}
+		return data, nil
+	}
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("malformed data URL: %s", err.Error()))
+	}
+	return []byte(data), nil
+}
@@ -0,0 +1,50 @@
+package csv
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeDataURL(t *testing.T) {
+	content := "name,qty\nfirst,1\n"
+	tests := []struct {
+		name    string
+		url     string
+		content string
+		err     string
+	}{
+		{"base64", "data:text/csv;base64," + base64.StdEncoding.EncodeToString([]byte(content)), content, ""},
+		{"base64_unpadded", "data:;base64," + base64.RawStdEncoding.EncodeToString([]byte("a,b\n1")), "a,b\n1", ""},
+		{"url_encoded", "data:text/csv,name%2Cqty%0Afirst%2C1%0A", content, ""},
+		{"plain", "data:,name", "name", ""},
+		{"no_comma", "data:text/csv;base64", "", "malformed data URL: missing `,` before the data"},
+		{"bad_base64", "data:text/csv;base64,!!!", "", "malformed data URL: illegal base64 data at input byte 0"},
+		{"bad_escape", "data:text/csv,%ZZ", "", "malformed data URL: invalid URL escape \"%ZZ\""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := decodeDataURL(test.url)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.content, string(data))
+		})
+	}
+}
+
+func TestLoadCSV_DataURL(t *testing.T) {
+	sqlite := newTestDB(t)
+	dataURL := "data:text/csv;base64," + base64.StdEncoding.EncodeToString([]byte("name,qty\nfirst,1\nsecond,2\n"))
+	stats := mustLoadCSV(t, sqlite, "sales", newTestDescriptor(dataURL))
+	assert.Equal(t, 2, stats.Rows)
+
+	// Same size, other data
+	dataURL = "data:text/csv;base64," + base64.StdEncoding.EncodeToString([]byte("name,qty\nfirst,1\nthird,3\n"))
+	stats = mustLoadCSV(t, sqlite, "sales", newTestDescriptor(dataURL))
+	assert.True(t, stats.Loaded)
+}
@@ -0,0 +1,147 @@
+package csv
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync/atomic"
+)
+
+var datasetCounter int64
+
+// Dataset is a CSV file loaded into its own in-memory DB, see DatasetCache
+type Dataset struct {
+	// Name of the table holding the CSV data
+	Table string
+	// Columns of the table, including auto-detected types
+	Columns []Column
+	Stats   *LoadStats
+	db      *DbSqlite
+	cache   *DatasetCache
+	key     string
+	// Table and file name, the versions of the same file have the same origin
+	origin string
+	// Guarded by DatasetCache.mu
+	refs    int
+	evicted bool
+}
+
+// Loads the descriptor into a new in-memory DB
+func newDataset(tableName string, descriptor *FileDescriptor, logger Logger) (*Dataset, error) {
+	// Every dataset gets its own named in-memory DB, so closing one never drops tables of another
+	dsn, err := buildDSN(fmt.Sprintf("file:dataset_%d", atomic.AddInt64(&datasetCounter, 1)), descriptor.DSNParams)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// The in-memory DB lives as long as at least one connection is open
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	sqlite := &DbSqlite{db: db, logger: logger}
+	if err := sqlite.Init(); err != nil {
+		_ = sqlite.Close()
+		return nil, err
+	}
+	stats, err := sqlite.LoadCSV(tableName, descriptor)
+	if err != nil {
+		_ = sqlite.Close()
+		return nil, err
+	}
+
+	return &Dataset{
+		Table:   stats.Table,
+		Columns: descriptor.Columns,
+		Stats:   stats,
+		db:      sqlite,
+	}, nil
+}
+
+// DSN parameters the loader depends on, they can not be overridden by FileDescriptor.DSNParams
+var requiredDSNParams = map[string]string{"mode": "memory", "cache": "shared"}
+
+// Appends the required and the extra parameters to the DSN
+func buildDSN(base string, params map[string]string) (string, error) {
+	values := url.Values{}
+	for key, value := range requiredDSNParams {
+		values.Set(key, value)
+	}
+	for key, value := range params {
+		if _, ok := requiredDSNParams[key]; ok {
+			return "", errors.New(fmt.Sprintf("DSN parameter `%s` can not be overridden", key))
+		}
+		values.Set(key, value)
+	}
+	return base + "?" + values.Encode(), nil
+}
+
+func (d *Dataset) Query(sql string) (*QueryResult, error) {
+	return d.db.Query(sql)
+}
+
+// ExportCSV writes the result of the query as CSV, see ExportOptions
+func (d *Dataset) ExportCSV(w io.Writer, sql string, options ExportOptions) error {
+	return d.db.ExportCSV(w, sql, options)
+}
+
+// ExportParquet writes the loaded table as Parquet
+func (d *Dataset) ExportParquet(w io.Writer) error {
+	return d.db.ExportParquet(w, d.Table)
+}
+
+// Snapshot writes the whole DB to a new SQLite file, e.g. to inspect a load in a SQLite GUI, see DbSqlite.Snapshot
+func (d *Dataset) Snapshot(path string) error {
+	return d.db.Snapshot(path)
+}
+
+// Tables returns the names of the tables in the DB (the loaded one and any others, e.g. of a reused DB)
+func (d *Dataset) Tables() ([]string, error) {
+	return d.db.tables()
+}
+
+// Schema returns the columns of the table as declared in the DB
+func (d *Dataset) Schema(tableName string) ([]Column, error) {
+	return d.db.schema(tableName)
+}
+
+// Size of the dataset counted against DatasetCache max size
+func (d *Dataset) size() int {
+	if d.Stats == nil {
+		return 0
+	}
+	return d.Stats.Rows
+}
+
+// Acquire keeps a cached dataset open until the matching Release, even if it is evicted meanwhile.
+// DatasetCache.Acquire does it already, it is only needed to share the dataset further, e.g. with another goroutine
+func (d *Dataset) Acquire() {
+	if d.cache == nil {
+		return
+	}
+	d.cache.mu.Lock()
+	defer d.cache.mu.Unlock()
+	d.refs++
+}
+
+// Release undoes Acquire (or DatasetCache.Acquire), the evicted dataset is closed with the last release
+func (d *Dataset) Release() {
+	if d.cache == nil {
+		return
+	}
+	d.cache.mu.Lock()
+	defer d.cache.mu.Unlock()
+	d.refs--
+	if d.evicted && d.refs == 0 {
+		d.cache.closeDataset(d)
+	}
+}
+
+// Closes the underlying DB, all tables are dropped
+func (d *Dataset) Close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,245 @@
+package csv
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// DatasetCache keeps loaded datasets between queries and evicts the least recently used ones.
+// A dataset is closed once it is evicted and no longer acquired, so eviction never breaks a running query.
+type DatasetCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxRows    int
+	rows       int
+	entries    map[string]*list.Element
+	lru        *list.List
+	logger     Logger
+
+	// Serve the previous version of a changed file while it is reloaded in the background, see Acquire.
+	// Must be set before the first Acquire
+	RefreshInBackground bool
+	// Origins being refreshed in the background
+	refreshing map[string]bool
+	// Keys which failed to refresh by origin, they are not retried until the file changes again
+	failed map[string]string
+	closed bool
+}
+
+// If maxEntries <= 0, the count of datasets is not limited
+// If maxRows <= 0, the total count of rows is not limited
+// If logger is nil, the package logger (see SetLogger) is used.
+func NewDatasetCache(maxEntries int, maxRows int, logger Logger) *DatasetCache {
+	if logger == nil {
+		logger = getDefaultLogger()
+	}
+	return &DatasetCache{
+		maxEntries: maxEntries,
+		maxRows:    maxRows,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		refreshing: make(map[string]bool),
+		failed:     make(map[string]string),
+		logger:     logger,
+	}
+}
+
+// Acquire returns the dataset of the descriptor, loading it if the file is not cached or has been changed.
+// With RefreshInBackground a changed file is reloaded in the background, meanwhile (and if the reload fails)
+// its previous version is returned. The new version replaces it once loaded.
+// The returned release func must be called once the dataset (and its query results) are not needed anymore.
+func (c *DatasetCache) Acquire(tableName string, descriptor *FileDescriptor) (*Dataset, func(), error) {
+	key, err := fingerprint(tableName, descriptor)
+	if err != nil {
+		return nil, nil, err
+	}
+	origin := tableName + "\x00" + descriptor.Filename
+
+	c.mu.Lock()
+	if dataset := c.get(key); dataset != nil {
+		c.mu.Unlock()
+		c.logger.Debug("Dataset cache hit", "table", tableName, "filename", descriptor.Filename)
+		return dataset, c.releaseFunc(dataset), nil
+	}
+	if c.RefreshInBackground {
+		if stale := c.getStale(origin); stale != nil {
+			if !c.refreshing[origin] && c.failed[origin] != key {
+				c.refreshing[origin] = true
+				go c.refresh(tableName, cloneDescriptor(descriptor), key, origin)
+			}
+			c.mu.Unlock()
+			c.logger.Debug("Dataset is stale", "table", tableName, "filename", descriptor.Filename)
+			return stale, c.releaseFunc(stale), nil
+		}
+	}
+	c.mu.Unlock()
+
+	c.logger.Debug("Dataset cache miss", "table", tableName, "filename", descriptor.Filename)
+	// The key is computed from the caller's descriptor, it must not get the loaded columns
+	dataset, err := newDataset(tableName, cloneDescriptor(descriptor), c.logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataset.key = key
+	dataset.origin = origin
+	dataset.cache = c
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dataset = c.add(dataset)
+	dataset.refs++
+	return dataset, c.releaseFunc(dataset), nil
+}
+
+// Loads the changed file, the stale version is kept if it fails
+func (c *DatasetCache) refresh(tableName string, descriptor *FileDescriptor, key string, origin string) {
+	c.logger.Debug("Refreshing dataset", "table", tableName, "filename", descriptor.Filename)
+	dataset, err := newDataset(tableName, descriptor, c.logger)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.refreshing, origin)
+	if err != nil {
+		c.failed[origin] = key
+		c.logger.Error("Failed to refresh dataset, the stale one is kept", "table", tableName, "filename", descriptor.Filename, "error", err.Error())
+		return
+	}
+	delete(c.failed, origin)
+	dataset.key = key
+	dataset.origin = origin
+	dataset.cache = c
+	if c.closed {
+		c.closeDataset(dataset)
+		return
+	}
+	c.add(dataset)
+}
+
+// Must be called under the lock.
+// Returns the cached dataset of the same key (e.g. loaded concurrently) or the added one
+func (c *DatasetCache) add(dataset *Dataset) *Dataset {
+	if element, ok := c.entries[dataset.key]; ok {
+		_ = dataset.Close()
+		c.lru.MoveToFront(element)
+		return element.Value.(*Dataset)
+	}
+	// The previous versions of the file are replaced, they are closed once their queries are finished
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*Dataset).origin == dataset.origin {
+			c.remove(element)
+		}
+		element = next
+	}
+	c.entries[dataset.key] = c.lru.PushFront(dataset)
+	c.rows += dataset.size()
+	c.evict()
+	return dataset
+}
+
+// Len returns the count of cached datasets
+func (c *DatasetCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close evicts all datasets, acquired ones are closed on release. Refreshed ones are dropped once loaded
+func (c *DatasetCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Must be called under the lock
+func (c *DatasetCache) get(key string) *Dataset {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	dataset := element.Value.(*Dataset)
+	dataset.refs++
+	return dataset
+}
+
+// Must be called under the lock
+func (c *DatasetCache) getStale(origin string) *Dataset {
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		if dataset := element.Value.(*Dataset); dataset.origin == origin {
+			c.lru.MoveToFront(element)
+			dataset.refs++
+			return dataset
+		}
+	}
+	return nil
+}
+
+// Must be called under the lock
+func (c *DatasetCache) evict() {
+	for c.lru.Len() > 1 && ((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxRows > 0 && c.rows > c.maxRows)) {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Must be called under the lock
+func (c *DatasetCache) remove(element *list.Element) {
+	dataset := element.Value.(*Dataset)
+	c.lru.Remove(element)
+	delete(c.entries, dataset.key)
+	c.rows -= dataset.size()
+	dataset.evicted = true
+	c.logger.Debug("Dataset evicted", "table", dataset.Table, "refs", dataset.refs)
+	if dataset.refs == 0 {
+		c.closeDataset(dataset)
+	}
+}
+
+// The func releases the dataset once, however many times it is called
+func (c *DatasetCache) releaseFunc(dataset *Dataset) func() {
+	var once sync.Once
+	return func() {
+		once.Do(dataset.Release)
+	}
+}
+
+func (c *DatasetCache) closeDataset(dataset *Dataset) {
+	if err := dataset.Close(); err != nil {
+		c.logger.Warn("Failed to close dataset", "table", dataset.Table, "error", err.Error())
+	}
+}
+
+// The load fills in the columns of the descriptor, the copy keeps the caller's one intact
+func cloneDescriptor(descriptor *FileDescriptor) *FileDescriptor {
+	clone := *descriptor
+	clone.Columns = append([]Column(nil), descriptor.Columns...)
+	return &clone
+}
+
+// Identifies the loaded data: the table, the file with its size and modification time and the load options
+func fingerprint(tableName string, descriptor *FileDescriptor) (string, error) {
+	fSize, fModTime, err := resolveSource(descriptor).Stat()
+	if err != nil {
+		return "", err
+	}
+	options := *descriptor
+	options.Source = nil
+	options.SchemaProvider = nil
+	optionsJson, err := json.Marshal(struct {
+		Table   string
+		Size    int64
+		ModTime int64
+		Options FileDescriptor
+	}{tableName, fSize, fModTime, options})
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(optionsJson)
+	return hex.EncodeToString(sum[:]), nil
+}
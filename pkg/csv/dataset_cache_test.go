@@ -0,0 +1,282 @@
+package csv
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustAcquire(t *testing.T, cache *DatasetCache, table string, filename string) (*Dataset, func()) {
+	dataset, release, err := cache.Acquire(table, newTestDescriptor(filename))
+	require.NoError(t, err)
+	return dataset, release
+}
+
+func TestDatasetCache_Reuse(t *testing.T) {
+	cache := NewDatasetCache(2, 0, nopLogger{})
+	defer cache.Close()
+	filename := writeTestCSV(t, "name,qty\nfirst,1\nsecond,2\n")
+
+	first, release := mustAcquire(t, cache, "sales", filename)
+	release()
+	second, release := mustAcquire(t, cache, "sales", filename)
+	release()
+	assert.Same(t, first, second)
+	assert.Equal(t, 2, first.Stats.Rows)
+
+	// The modified file is loaded again
+	modTime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filename, modTime, modTime))
+	third, release := mustAcquire(t, cache, "sales", filename)
+	defer release()
+	assert.NotSame(t, first, third)
+}
+
+func TestDatasetCache_ReuseDescriptor(t *testing.T) {
+	cache := NewDatasetCache(2, 0, nopLogger{})
+	defer cache.Close()
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty\nfirst,1\n"))
+
+	first, release, err := cache.Acquire("sales", descriptor)
+	require.NoError(t, err)
+	release()
+	assert.Empty(t, descriptor.Columns)
+	second, release, err := cache.Acquire("sales", descriptor)
+	require.NoError(t, err)
+	defer release()
+	assert.Same(t, first, second)
+}
+
+func TestDatasetCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewDatasetCache(2, 0, nopLogger{})
+	defer cache.Close()
+	first := writeTestCSV(t, "name\nfirst\n")
+	second := writeTestCSV(t, "name\nsecond\n")
+	third := writeTestCSV(t, "name\nthird\n")
+
+	firstDataset, release := mustAcquire(t, cache, "t", first)
+	release()
+	_, release = mustAcquire(t, cache, "t", second)
+	release()
+	// first becomes the most recently used
+	_, release = mustAcquire(t, cache, "t", first)
+	release()
+	_, release = mustAcquire(t, cache, "t", third)
+	release()
+
+	assert.Equal(t, 2, cache.Len())
+	dataset, release := mustAcquire(t, cache, "t", first)
+	defer release()
+	assert.Same(t, firstDataset, dataset)
+}
+
+func TestDatasetCache_MaxRows(t *testing.T) {
+	cache := NewDatasetCache(0, 3, nopLogger{})
+	defer cache.Close()
+
+	_, release := mustAcquire(t, cache, "t", writeTestCSV(t, "name\na\nb\n"))
+	release()
+	_, release = mustAcquire(t, cache, "t", writeTestCSV(t, "name\nc\nd\n"))
+	release()
+
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestDatasetCache_EvictionKeepsAcquiredDataset(t *testing.T) {
+	cache := NewDatasetCache(1, 0, nopLogger{})
+	defer cache.Close()
+
+	busy, releaseBusy := mustAcquire(t, cache, "t", writeTestCSV(t, "name\nbusy\n"))
+	_, release := mustAcquire(t, cache, "t", writeTestCSV(t, "name\nother\n"))
+	release()
+	assert.Equal(t, 1, cache.Len())
+
+	// Evicted, but still queryable until released
+	result, err := busy.Query("SELECT name FROM t")
+	require.NoError(t, err)
+	row, err := result.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "busy", row[0])
+	result.Release()
+
+	releaseBusy()
+	_, err = busy.Query("SELECT name FROM t")
+	assert.Error(t, err)
+}
+
+func TestDataset_TablesAndSchema(t *testing.T) {
+	cache := NewDatasetCache(1, 0, nopLogger{})
+	defer cache.Close()
+	dataset, release := mustAcquire(t, cache, "sales", writeTestCSV(t, "name,qty,price\nfirst,1,1.5\n"))
+	defer release()
+
+	tables, err := dataset.Tables()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sales"}, tables)
+
+	columns, err := dataset.Schema("sales")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger},
+		{Name: "price", Type: ColumnTypeReal},
+	}, columns)
+
+	_, err = dataset.Schema("missing")
+	assert.EqualError(t, err, "table `missing` does not exist")
+}
+
+func TestBuildDSN(t *testing.T) {
+	dsn, err := buildDSN("file:test", map[string]string{"_foreign_keys": "on", "_loc": "auto"})
+	require.NoError(t, err)
+	assert.Equal(t, "file:test?_foreign_keys=on&_loc=auto&cache=shared&mode=memory", dsn)
+
+	_, err = buildDSN("file:test", map[string]string{"mode": "rwc"})
+	assert.EqualError(t, err, "DSN parameter `mode` can not be overridden")
+}
+
+func TestDatasetCache_DSNParams(t *testing.T) {
+	cache := NewDatasetCache(1, 0, nopLogger{})
+	defer cache.Close()
+	descriptor := newTestDescriptor(writeTestCSV(t, "name\nfirst\n"))
+	descriptor.DSNParams = map[string]string{"_foreign_keys": "on"}
+	dataset, release, err := cache.Acquire("t", descriptor)
+	require.NoError(t, err)
+	defer release()
+
+	result, err := dataset.Query("PRAGMA foreign_keys")
+	require.NoError(t, err)
+	defer result.Release()
+	row, err := result.Next()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), row[0])
+}
+
+func TestDatasetCache_ReloadReplacesPreviousVersion(t *testing.T) {
+	cache := NewDatasetCache(10, 0, nopLogger{})
+	defer cache.Close()
+	filename := writeTestCSV(t, "name\nfirst\n")
+
+	previous, release := mustAcquire(t, cache, "t", filename)
+	// A query is still running
+	previous.Acquire()
+	release()
+
+	modTime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filename, modTime, modTime))
+	_, release = mustAcquire(t, cache, "t", filename)
+	release()
+	assert.Equal(t, 1, cache.Len())
+
+	result, err := previous.Query("SELECT name FROM t")
+	require.NoError(t, err)
+	result.Release()
+	previous.Release()
+	_, err = previous.Query("SELECT name FROM t")
+	assert.Error(t, err)
+}
+
+func TestDatasetCache_Concurrent(t *testing.T) {
+	cache := NewDatasetCache(1, 0, nopLogger{})
+	defer cache.Close()
+	filenames := []string{
+		writeTestCSV(t, "name\nfirst\n"),
+		writeTestCSV(t, "name\nsecond\n"),
+		writeTestCSV(t, "name\nthird\n"),
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(filename string) {
+			defer wg.Done()
+			dataset, release, err := cache.Acquire("t", newTestDescriptor(filename))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer release()
+			result, err := dataset.Query("SELECT COUNT(*) FROM t")
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = result.Next()
+			result.Release()
+			if err != nil {
+				errs <- err
+			}
+		}(filenames[i%len(filenames)])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestDatasetCache_RefreshInBackground(t *testing.T) {
+	cache := NewDatasetCache(0, 0, nopLogger{})
+	cache.RefreshInBackground = true
+	defer cache.Close()
+	filename := writeTestCSV(t, "name,qty\nfirst,1\n")
+
+	stale, release := mustAcquire(t, cache, "sales", filename)
+	release()
+
+	// The stale dataset is served until the changed file is loaded
+	require.NoError(t, ioutil.WriteFile(filename, []byte("name,qty\nfirst,1\nsecond,2\n"), 0644))
+	require.NoError(t, os.Chtimes(filename, time.Now(), time.Unix(100, 0)))
+	dataset, release := mustAcquire(t, cache, "sales", filename)
+	release()
+	assert.Same(t, stale, dataset)
+	// The condition runs on another goroutine, so it reports the error instead of failing the test there
+	acquireErrors := make(chan error, 1)
+	loaded := assert.Eventually(t, func() bool {
+		dataset, release, err := cache.Acquire("sales", newTestDescriptor(filename))
+		if err != nil {
+			select {
+			case acquireErrors <- err:
+			default:
+			}
+			return false
+		}
+		defer release()
+		return dataset.Stats.Rows == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	select {
+	case err := <-acquireErrors:
+		require.NoError(t, err)
+	default:
+	}
+	require.True(t, loaded)
+	assert.Equal(t, 1, cache.Len())
+
+	// A failed refresh keeps the stale dataset and is not retried until the file changes
+	refreshed, release := mustAcquire(t, cache, "sales", filename)
+	release()
+	require.NoError(t, ioutil.WriteFile(filename, []byte(",\nfirst,1\n"), 0644))
+	require.NoError(t, os.Chtimes(filename, time.Now(), time.Unix(200, 0)))
+	dataset, release = mustAcquire(t, cache, "sales", filename)
+	release()
+	assert.Same(t, refreshed, dataset)
+	assert.Eventually(t, func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return len(cache.failed) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	dataset, release = mustAcquire(t, cache, "sales", filename)
+	defer release()
+	assert.Same(t, refreshed, dataset)
+	cache.mu.Lock()
+	assert.Empty(t, cache.refreshing)
+	cache.mu.Unlock()
+}
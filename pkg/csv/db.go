@@ -1,38 +1,163 @@
 package csv
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
 const (
-	ColumnTypeText = "text"
-	ColumnTypeInteger = "integer"
-	ColumnTypeReal = "real"
-	ColumnTypeTimestamp = "timestamp"
-	ColumnTypeDate = "date"
+	ColumnTypeText ColumnType = "text"
+	ColumnTypeInteger ColumnType = "integer"
+	ColumnTypeReal ColumnType = "real"
+	ColumnTypeTimestamp ColumnType = "timestamp"
+	ColumnTypeDate ColumnType = "date"
+	// Detected if the sampled values are words (true/false, yes/no) or 0/1.
+	// A column of 0/1 is integer unless it is declared boolean
+	ColumnTypeBoolean ColumnType = "boolean"
 )
 
 type ColumnType string
 
+// Values of FileDescriptor.TypeDefaults, any other value is a literal default
+const (
+	// Empty values are stored as NULL
+	TypeDefaultNull = "null"
+	// Empty values are stored as the zero value of the type: 0, '' or the Unix epoch for dates
+	TypeDefaultZero = "zero"
+)
+
+// How NUL bytes in values are handled, see FileDescriptor.NulBytes
+const (
+	// NUL bytes are removed from the value, with a warning
+	NulBytesStrip = "strip"
+	// A value with a NUL byte fails the load
+	NulBytesReject = "reject"
+)
+
 type Column struct {
 	Type ColumnType
 	Name string
+	// Date used for empty values of a date column, any format understood by dateparse.
+	// Without it an empty date value is stored as NULL, it is never replaced with the load time.
+	DefaultDate string
+	// Go layouts (e.g. 02.01.2006) of a date column, tried in order before guessing the format
+	Formats []string
+	// The last layout recognized by dateparse, see parseDate
+	dateLayout string
+	// Unit of measure, filled from the units row (see FileDescriptor.UnitsRow)
+	Unit string
+	// Date and timestamp values are truncated to a multiple of it (e.g. time.Minute: 15:04:37 -> 15:04:00), no-op if zero
+	TruncateTo time.Duration
+	// Base of integer values: 2, 8, 10 or 16, the 0x/0o/0b prefix is optional.
+	// 0 (default) is decimal, but prefixed values (0x1F, 0o17, 0b101) are parsed in their base.
+	// With base 2, 8 or 16 such values are detected as integers
+	IntBase int
+	// Separator of digit groups (e.g. `,` for 1,234.5), it is removed from integer and real values
+	ThousandsSeparator string
+	// Validates grouping of numbers with ThousandsSeparator: GroupingWestern or GroupingIndian.
+	// Malformed numbers (e.g. 1,234,56) are still parsed and reported in LoadStats.Warnings. Off if empty
+	Grouping string
+	// Expected values of a categorical column, others are stored as well but reported in LoadStats.Warnings
+	// (or fail the load in strict mode). No check if empty
+	AllowedValues []string
+	// Match AllowedValues case insensitively
+	AllowedValuesIgnoreCase bool
+	// Characters trimmed from both ends of the values before conversion, e.g. `*` for `***42`
+	TrimCutset string
+	// Store empty values of an integer or real column as 0 (also its DEFAULT), they are NULL otherwise.
+	// It takes precedence over FileDescriptor.TypeDefaults
+	EmptyAsZero bool
+	// Range of integer and real values, not checked if nil. Values out of it are reported in LoadStats.Warnings
+	// (or fail the load in strict mode)
+	Min *float64
+	Max *float64
+	// 1-based position of the column in the file, it is matched by Name if zero.
+	// E.g. for a file without header (see FileDescriptor.NoHeader) whose columns are not in the definition order
+	Index int
+	// Values stored as NULL in this column (e.g. `-1`), they replace FileDescriptor.NullValues if not empty
+	NullValues []string
+	// SQL expression of a generated column (e.g. `price * qty`), computed by SQLite on read rather than loaded.
+	// It may refer to the other columns by name, the column itself must not be in the CSV
+	Expression string
+}
+
+func (c *Column) isGenerated() bool {
+	return c.Expression != ""
+}
+
+func (c *Column) isAllowed(value string) bool {
+	for _, allowed := range c.AllowedValues {
+		if allowed == value || (c.AllowedValuesIgnoreCase && strings.EqualFold(allowed, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Column) trim(value string) string {
+	if c.TrimCutset == "" {
+		return value
+	}
+	return strings.Trim(value, c.TrimCutset)
 }
 
 type DB interface {
 	Init() error
 	Query(sql string) (*QueryResult, error)
-	LoadCSV(tableName string, descriptor *FileDescriptor) error
+	LoadCSV(tableName string, descriptor *FileDescriptor) (*LoadStats, error)
+	LoadCSVContext(ctx context.Context, tableName string, descriptor *FileDescriptor) (*LoadStats, error)
+	LoadFrom(tableName string, r io.Reader, descriptor *FileDescriptor) (*LoadStats, error)
+	LoadTar(r io.Reader, descriptor *FileDescriptor) ([]string, error)
+	LoadFiles(tableName string, descriptors []*FileDescriptor, options LoadFilesOptions) (*LoadStats, error)
+	ExportCSV(w io.Writer, sql string, options ExportOptions) error
+	Close() error
+}
+
+// LoadStats describes the outcome of DB.LoadCSV
+type LoadStats struct {
+	// Name of the table, derived from the file name if not given
+	Table string
+	// False if the table is already loaded and the file has not been changed since
+	Loaded bool
+	// Count of rows in the table
+	Rows int
+	// Count of duplicate rows removed, see FileDescriptor.Distinct
+	Duplicates int
+	// Non fatal problems of the data, e.g. malformed grouping of numbers (see Column.Grouping)
+	Warnings []string
+	// The first raw bytes of the source, see FileDescriptor.CaptureRawBytes
+	RawPrefix []byte
 }
 
 func ColumnTypeFromString(s string) ColumnType {
-	switch s {
-	case "text":
-		return ColumnTypeText
-	case "integer":
-		return ColumnTypeInteger
-	case "real":
-		return ColumnTypeReal
+	columnType, _ := ParseColumnType(s)
+	return columnType
+}
+
+// ParseColumnType converts a type name (case insensitive) into ColumnType.
+// Aliases: int -> integer, float/double -> real, string -> text, bool -> boolean
+func ParseColumnType(s string) (ColumnType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "text", "string":
+		return ColumnTypeText, nil
+	case "integer", "int":
+		return ColumnTypeInteger, nil
+	case "real", "float", "double":
+		return ColumnTypeReal, nil
 	case "date":
-		return ColumnTypeDate
+		return ColumnTypeDate, nil
 	case "timestamp":
-		return ColumnTypeTimestamp
+		return ColumnTypeTimestamp, nil
+	case "boolean", "bool":
+		return ColumnTypeBoolean, nil
 	}
-	return ""
+	return "", errors.New(fmt.Sprintf("unknown column type `%s`, expected one of: text, integer, real, boolean, date, timestamp", s))
+}
+
+func (t ColumnType) String() string {
+	return string(t)
 }
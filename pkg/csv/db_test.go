@@ -0,0 +1,35 @@
+package csv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseColumnType(t *testing.T) {
+	tests := map[string]ColumnType{
+		"text":      ColumnTypeText,
+		"string":    ColumnTypeText,
+		"INTEGER":   ColumnTypeInteger,
+		"int":       ColumnTypeInteger,
+		"real":      ColumnTypeReal,
+		"float":     ColumnTypeReal,
+		" date ":    ColumnTypeDate,
+		"timestamp": ColumnTypeTimestamp,
+		"Bool":      ColumnTypeBoolean,
+	}
+	for s, expected := range tests {
+		columnType, err := ParseColumnType(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, columnType, s)
+	}
+
+	_, err := ParseColumnType("blob")
+	assert.EqualError(t, err, "unknown column type `blob`, expected one of: text, integer, real, boolean, date, timestamp")
+}
+
+func TestColumnTypeString(t *testing.T) {
+	columnType, err := ParseColumnType(ColumnTypeReal.String())
+	assert.NoError(t, err)
+	assert.Equal(t, ColumnTypeReal, columnType)
+}
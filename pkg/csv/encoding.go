@@ -0,0 +1,52 @@
+package csv
+
+import (
+	"bufio"
+	"errors"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+	"io"
+	"unicode/utf8"
+)
+
+// Bytes inspected by FileDescriptor.DetectEncoding
+const encodingSampleSize = 64 * 1024
+
+// Converts the data to UTF-8.
+// The detection is best-effort: data which is valid UTF-8 in the sample is read as is, otherwise the charset
+// is taken from a byte order mark, falling back to windows-1252 (which also covers iso-8859-1).
+func decodeContent(data io.Reader, descriptor *FileDescriptor) (io.Reader, error) {
+	if descriptor.Encoding != "" {
+		e, _ := charset.Lookup(descriptor.Encoding)
+		return transform.NewReader(data, e.NewDecoder()), nil
+	}
+	if !descriptor.DetectEncoding {
+		return data, nil
+	}
+
+	buffered := bufio.NewReaderSize(data, encodingSampleSize)
+	sample, err := buffered.Peek(encodingSampleSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if validUTF8Prefix(sample, err == nil) {
+		return buffered, nil
+	}
+	e, _, _ := charset.DetermineEncoding(sample, "text/csv")
+	return transform.NewReader(buffered, e.NewDecoder()), nil
+}
+
+// A rune cut by the end of a partial sample does not make it invalid
+func validUTF8Prefix(sample []byte, partial bool) bool {
+	if partial {
+		for i := len(sample) - 1; i >= 0 && i > len(sample)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(sample[i]) {
+				if !utf8.FullRune(sample[i:]) {
+					sample = sample[:i]
+				}
+				break
+			}
+		}
+	}
+	return utf8.Valid(sample)
+}
@@ -0,0 +1,89 @@
+package csv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCSV_DetectEncoding(t *testing.T) {
+	// Windows-1252 without any declaration: é is 0xE9, ü is 0xFC, € is 0x80
+	content := "name,city,price\nJos\xe9,Z\xfcrich,\x80 5\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.DetectEncoding = true
+	mustLoadCSV(t, sqlite, "people", descriptor)
+
+	var name, city, price string
+	require.NoError(t, sqlite.db.QueryRow("SELECT name, city, price FROM people").Scan(&name, &city, &price))
+	assert.Equal(t, "José", name)
+	assert.Equal(t, "Zürich", city)
+	assert.Equal(t, "€ 5", price)
+
+	// The explicit encoding wins over detection
+	descriptor = newTestDescriptor(writeTestCSV(t, "name\nJos\xe9\n"))
+	descriptor.DetectEncoding = true
+	descriptor.Encoding = "iso-8859-7"
+	mustLoadCSV(t, sqlite, "people", descriptor)
+	require.NoError(t, sqlite.db.QueryRow("SELECT name FROM people").Scan(&name))
+	assert.Equal(t, "Josι", name)
+}
+
+func TestLoadCSV_DetectEncodingKeepsUTF8(t *testing.T) {
+	// A multibyte rune is cut by the end of the sample
+	padding := strings.Repeat("a", encodingSampleSize-len("name\n")-1)
+	content := "name\n" + padding + "é\nZürich\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.DetectEncoding = true
+	mustLoadCSV(t, sqlite, "people", descriptor)
+
+	var count int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM people WHERE name IN (?, 'Zürich')", padding+"é").Scan(&count))
+	assert.Equal(t, 2, count)
+}
+
+func TestLoadCSV_UnknownEncoding(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name\nfirst\n"))
+	descriptor.Encoding = "klingon"
+	_, err := sqlite.LoadCSV("people", descriptor)
+	assert.EqualError(t, err, "unknown encoding `klingon`")
+}
+
+func TestLoadCSV_CaptureRawBytes(t *testing.T) {
+	content := "name\nJos\xe9\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Encoding = "windows-1252"
+	descriptor.CaptureRawBytes = 9
+	stats := mustLoadCSV(t, sqlite, "people", descriptor)
+	// Before decoding
+	assert.Equal(t, []byte("name\nJos\xe9"), stats.RawPrefix)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Encoding = "windows-1252"
+	descriptor.CaptureRawBytes = 1 << 20
+	stats = mustLoadCSV(t, sqlite, "people_all", descriptor)
+	assert.Equal(t, []byte(content), stats.RawPrefix)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Encoding = "windows-1252"
+	stats = mustLoadCSV(t, sqlite, "people_none", descriptor)
+	assert.Nil(t, stats.RawPrefix)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	w := &prefixWriter{max: 5}
+	for _, chunk := range []string{"abc", "defg", "hij"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, []byte("abcde"), w.prefix)
+}
@@ -0,0 +1,158 @@
+package csv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportOptions controls how ExportCSV writes a query result
+type ExportOptions struct {
+	// `,` if not set
+	Delimiter rune
+	// Do not write the header line
+	NoHeader bool
+	// Written for NULL values of every column, empty if not set
+	NullOutput string
+	// Keep the type of NULL values: an unquoted empty field for non text columns and a quoted empty field ("")
+	// for text ones, so strict parsers tell a missing number from an empty string. NullOutput is ignored
+	TypedNulls bool
+	// Go layout of dates, RFC3339 with fractional seconds if not set
+	DateLayout string
+}
+
+// Layout of exported dates, dateparse reads it back
+const exportDateLayout = time.RFC3339Nano
+
+// ExportCSV writes the result of the query as CSV
+func (sqlite *DbSqlite) ExportCSV(w io.Writer, sql string, options ExportOptions) error {
+	result, err := sqlite.Query(sql)
+	if err != nil {
+		return err
+	}
+	defer result.Release()
+
+	columnTypes, err := result.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	textColumns := make([]bool, len(columnTypes))
+	for i, columnType := range columnTypes {
+		switch strings.ToUpper(columnType.DatabaseTypeName()) {
+		case "INTEGER", "REAL", "TIMESTAMP", "DATE", "DATETIME", "BOOLEAN":
+		default:
+			textColumns[i] = true
+		}
+	}
+
+	writer := newExportWriter(w, options)
+	if !options.NoHeader {
+		columns, err := result.Columns()
+		if err != nil {
+			return err
+		}
+		writer.header(columns)
+	}
+
+	for {
+		values, err := result.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		writer.row(values, textColumns)
+	}
+	return writer.w.Flush()
+}
+
+func (options ExportOptions) format(value interface{}) string {
+	if date, ok := value.(time.Time); ok && options.DateLayout != "" {
+		return date.Format(options.DateLayout)
+	}
+	return formatExportValue(value)
+}
+
+func formatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(exportDateLayout)
+	}
+	return fmt.Sprint(value)
+}
+
+// Unlike encoding/csv it can quote an empty field, see ExportOptions.TypedNulls
+type exportWriter struct {
+	w         *bufio.Writer
+	delimiter rune
+	options   ExportOptions
+}
+
+func newExportWriter(w io.Writer, options ExportOptions) *exportWriter {
+	writer := &exportWriter{w: bufio.NewWriter(w), delimiter: options.Delimiter, options: options}
+	if writer.delimiter == 0 {
+		writer.delimiter = ','
+	}
+	return writer
+}
+
+func (e *exportWriter) header(columns []string) {
+	for i, column := range columns {
+		e.field(i, column, false)
+	}
+	e.endLine()
+}
+
+// textColumns tells which NULL values are written as a quoted empty field with ExportOptions.TypedNulls
+func (e *exportWriter) row(values []interface{}, textColumns []bool) {
+	for i, value := range values {
+		if value == nil {
+			if e.options.TypedNulls {
+				e.field(i, "", textColumns[i])
+			} else {
+				e.field(i, e.options.NullOutput, false)
+			}
+			continue
+		}
+		e.field(i, e.options.format(value), false)
+	}
+	e.endLine()
+}
+
+func (e *exportWriter) field(index int, value string, forceQuotes bool) {
+	if index > 0 {
+		e.w.WriteRune(e.delimiter)
+	}
+	if !forceQuotes && !e.needsQuotes(value) {
+		e.w.WriteString(value)
+		return
+	}
+	e.w.WriteByte('"')
+	e.w.WriteString(strings.ReplaceAll(value, `"`, `""`))
+	e.w.WriteByte('"')
+}
+
+func (e *exportWriter) endLine() {
+	e.w.WriteByte('\n')
+}
+
+func (e *exportWriter) needsQuotes(value string) bool {
+	if value == "" {
+		return false
+	}
+	return strings.ContainsRune(value, e.delimiter) || strings.ContainsAny(value, "\"\r\n") || value[0] == ' ' || value[0] == '\t'
+}
@@ -0,0 +1,39 @@
+package csv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportCSV_Nulls(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty,price,note\nfirst,1,1.5,\"a, b\"\nsecond,,,\n"))
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger},
+		{Name: "price", Type: ColumnTypeReal},
+		{Name: "note", Type: ColumnTypeText},
+	}
+	descriptor.NullifyBlank = true
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	tests := []struct {
+		name     string
+		options  ExportOptions
+		expected string
+	}{
+		{"empty", ExportOptions{}, "name,qty,price,note\nfirst,1,1.5,\"a, b\"\nsecond,,,\n"},
+		{"null_output", ExportOptions{NullOutput: "NULL", Delimiter: ';'}, "name;qty;price;note\nfirst;1;1.5;a, b\nsecond;NULL;NULL;NULL\n"},
+		{"typed_nulls", ExportOptions{TypedNulls: true, NoHeader: true}, "first,1,1.5,\"a, b\"\nsecond,,,\"\"\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, sqlite.ExportCSV(&out, "SELECT * FROM sales ORDER BY rowid", test.options))
+			assert.Equal(t, test.expected, out.String())
+		})
+	}
+}
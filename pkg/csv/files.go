@@ -0,0 +1,225 @@
+package csv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LoadFilesOptions controls how LoadFiles combines the files
+type LoadFilesOptions struct {
+	// The table has the union of the columns of all files (in the order of their first occurrence),
+	// the columns a file lacks are NULL in its rows. Otherwise every file must have the columns of the first one
+	SchemaUnion bool
+	// Remove duplicate rows of the whole table, only the first occurrence is kept.
+	// FileDescriptor.Distinct removes the duplicates within its file only
+	Distinct bool
+}
+
+// LoadFiles loads several files with the same kind of data (e.g. monthly exports) into one table, in the given order.
+// Each file is loaded by its own descriptor into a staging table, then the table is built from them at once,
+// so nothing is changed if any file fails. Types of a column which differ between the files are widened:
+// integer and real -> real, boolean and integer -> integer, anything else -> text.
+// Generated columns (see Column.Expression) stay generated in the table, so they must be the same in every file having them.
+// Unlike LoadCSV the files are always loaded, changes are not tracked. PostLoadSQL of the files is not supported.
+// The descriptors are not changed.
+func (sqlite *DbSqlite) LoadFiles(tableName string, descriptors []*FileDescriptor, options LoadFilesOptions) (*LoadStats, error) {
+	if len(descriptors) == 0 {
+		return nil, errors.New("there are no files to load")
+	}
+	for _, descriptor := range descriptors {
+		if len(descriptor.PostLoadSQL) > 0 {
+			return nil, errors.New(fmt.Sprintf("file `%s`: PostLoadSQL is not supported by LoadFiles", descriptor.Filename))
+		}
+	}
+
+	stagingTables := make([]string, 0)
+	dropStaging := func(conn execer) error {
+		for _, stagingTable := range stagingTables {
+			if err := sqlite.dropFilesStagingTable(conn, stagingTable); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer func() {
+		_ = dropStaging(sqlite.db)
+	}()
+
+	stats := &LoadStats{Table: tableName, Loaded: true}
+	schemas := make([][]Column, 0)
+	for i, descriptor := range descriptors {
+		stagingTable := filesStagingTable(tableName, i)
+		stagingTables = append(stagingTables, stagingTable)
+		// A leftover of a failed load would be taken for an already loaded file
+		if err := sqlite.dropFilesStagingTable(sqlite.db, stagingTable); err != nil {
+			return nil, err
+		}
+		loaded := cloneDescriptor(descriptor)
+		fileStats, err := sqlite.loadCSV(context.Background(), stagingTable, loaded)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("file `%s`: %s", descriptor.Filename, err.Error()))
+		}
+		stats.Rows += fileStats.Rows
+		stats.Duplicates += fileStats.Duplicates
+		for _, warning := range fileStats.Warnings {
+			stats.Warnings = append(stats.Warnings, fmt.Sprintf("file `%s`: %s", descriptor.Filename, warning))
+		}
+
+		columns, err := sqlite.schema(stagingTable)
+		if err != nil {
+			return nil, err
+		}
+		// The schema of the table lacks the expressions of generated columns
+		for j := range columns {
+			for _, column := range loaded.Columns {
+				if column.Name == columns[j].Name {
+					columns[j].Expression = column.Expression
+				}
+			}
+		}
+		schemas = append(schemas, columns)
+	}
+
+	columns, err := unionColumns(descriptors, schemas, options.SchemaUnion)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if err := sqlite.exec(tx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(tableName))); err != nil {
+		return nil, err
+	}
+	// The table is no longer the one of a single file
+	if err := sqlite.deleteMetaCsv(tx, tableName); err != nil {
+		return nil, err
+	}
+	if err := sqlite.exec(tx, createTableFor(tableName, columns, nil)); err != nil {
+		return nil, err
+	}
+	// Generated columns are computed by the table
+	insertedNames := make([]string, 0)
+	quotedNames := make([]string, 0)
+	for _, column := range columns {
+		if !column.isGenerated() {
+			insertedNames = append(insertedNames, column.Name)
+			quotedNames = append(quotedNames, quoteIdentifier(column.Name))
+		}
+	}
+	for i, stagingTable := range stagingTables {
+		selects := make([]string, 0)
+		for _, column := range columns {
+			if column.isGenerated() {
+				continue
+			}
+			if indexOf(getColumnNames(schemas[i]), column.Name) == -1 {
+				selects = append(selects, "NULL")
+			} else {
+				selects = append(selects, quoteIdentifier(column.Name))
+			}
+		}
+		err := sqlite.exec(tx, fmt.Sprintf(
+			"INSERT INTO %s(%s) SELECT %s FROM %s ORDER BY rowid",
+			quoteIdentifier(tableName),
+			strings.Join(quotedNames, ","),
+			strings.Join(selects, ","),
+			quoteIdentifier(stagingTable),
+		))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Distinct {
+		duplicates, err := sqlite.removeDuplicates(tx, tableName, insertedNames)
+		if err != nil {
+			return nil, err
+		}
+		stats.Duplicates += duplicates
+		stats.Rows -= duplicates
+	}
+	if err := dropStaging(tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	sqlite.logger.Info("CSV files have been loaded", "table", tableName, "files", len(descriptors), "rows", stats.Rows)
+	return stats, nil
+}
+
+func filesStagingTable(tableName string, index int) string {
+	return tableName + "__file" + strconv.Itoa(index+1)
+}
+
+// Staging tables are loaded like any other table, so they have meta too
+func (sqlite *DbSqlite) dropFilesStagingTable(conn execer, stagingTable string) error {
+	if err := sqlite.exec(conn, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(stagingTable))); err != nil {
+		return err
+	}
+	return sqlite.deleteMetaCsv(conn, stagingTable)
+}
+
+// Columns of the table: the ones of the first file or the union of all files, the types are widened
+func unionColumns(descriptors []*FileDescriptor, schemas [][]Column, schemaUnion bool) ([]Column, error) {
+	columns := append([]Column(nil), schemas[0]...)
+	for i, schema := range schemas[1:] {
+		if !schemaUnion && !sameColumnNames(columns, schema) {
+			return nil, errors.New(fmt.Sprintf(
+				"file `%s` has columns `%s`, but `%s` has `%s` (see LoadFilesOptions.SchemaUnion)",
+				descriptors[i+1].Filename, strings.Join(getColumnNames(schema), ", "),
+				descriptors[0].Filename, strings.Join(getColumnNames(columns), ", "),
+			))
+		}
+		for _, column := range schema {
+			j := indexOf(getColumnNames(columns), column.Name)
+			if j == -1 {
+				columns = append(columns, column)
+				continue
+			}
+			if columns[j].isGenerated() || column.isGenerated() {
+				if columns[j].Expression != column.Expression || columns[j].Type != column.Type {
+					return nil, errors.New(fmt.Sprintf(
+						"column `%s` of file `%s` differs from the one of the previous files, a generated column must be the same in every file",
+						column.Name, descriptors[i+1].Filename,
+					))
+				}
+				continue
+			}
+			columns[j].Type = widenType(columns[j].Type, column.Type)
+		}
+	}
+	return columns, nil
+}
+
+func sameColumnNames(a []Column, b []Column) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, column := range b {
+		if indexOf(getColumnNames(a), column.Name) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// The type able to hold the values of both types
+func widenType(a ColumnType, b ColumnType) ColumnType {
+	switch {
+	case a == b:
+		return a
+	case isNumericType(a) && isNumericType(b):
+		return ColumnTypeReal
+	case (a == ColumnTypeBoolean && b == ColumnTypeInteger) || (a == ColumnTypeInteger && b == ColumnTypeBoolean):
+		return ColumnTypeInteger
+	}
+	return ColumnTypeText
+}
@@ -0,0 +1,137 @@
+package csv
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFiles_SchemaUnion(t *testing.T) {
+	sqlite := newTestDB(t)
+	// February adds a discount, March drops the region and has fractional quantities
+	descriptors := []*FileDescriptor{
+		newTestDescriptor(writeTestCSV(t, "id,region,qty\n1,north,5\n2,south,3\n")),
+		newTestDescriptor(writeTestCSV(t, "id,region,qty,discount\n3,east,7,0.5\n")),
+		newTestDescriptor(writeTestCSV(t, "id,qty,discount\n4,2.5,1\n")),
+	}
+
+	stats, err := sqlite.LoadFiles("sales", descriptors, LoadFilesOptions{SchemaUnion: true})
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Rows)
+
+	columns, err := sqlite.schema("sales")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "id", Type: ColumnTypeInteger},
+		{Name: "region", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeReal},
+		{Name: "discount", Type: ColumnTypeReal},
+	}, columns)
+
+	rows, err := sqlite.db.Query("SELECT region, qty, discount FROM sales ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	values := make([][]interface{}, 0)
+	for rows.Next() {
+		var region sql.NullString
+		var qty, discount sql.NullFloat64
+		require.NoError(t, rows.Scan(&region, &qty, &discount))
+		values = append(values, []interface{}{region, qty, discount})
+	}
+	assert.Equal(t, [][]interface{}{
+		{sql.NullString{String: "north", Valid: true}, sql.NullFloat64{Float64: 5, Valid: true}, sql.NullFloat64{}},
+		{sql.NullString{String: "south", Valid: true}, sql.NullFloat64{Float64: 3, Valid: true}, sql.NullFloat64{}},
+		{sql.NullString{String: "east", Valid: true}, sql.NullFloat64{Float64: 7, Valid: true}, sql.NullFloat64{Float64: 0.5, Valid: true}},
+		{sql.NullString{}, sql.NullFloat64{Float64: 2.5, Valid: true}, sql.NullFloat64{Float64: 1, Valid: true}},
+	}, values)
+
+	// Nothing of the staging is left
+	tables, err := sqlite.tables()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sales"}, tables)
+	var metas int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM "+metaCsvTable).Scan(&metas))
+	assert.Equal(t, 0, metas)
+
+	// Loaded again, e.g. once a new month is added
+	stats, err = sqlite.LoadFiles("sales", descriptors[:2], LoadFilesOptions{SchemaUnion: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, countRows(t, sqlite, "sales"))
+}
+
+func TestLoadFiles_DifferentColumns(t *testing.T) {
+	sqlite := newTestDB(t)
+	first := newTestDescriptor(writeTestCSV(t, "id,qty\n1,5\n"))
+	second := newTestDescriptor(writeTestCSV(t, "qty,id\n3,2\n"))
+	third := newTestDescriptor(writeTestCSV(t, "id,qty,discount\n3,7,0.5\n"))
+
+	// The order of the columns does not matter
+	stats, err := sqlite.LoadFiles("sales", []*FileDescriptor{first, second}, LoadFilesOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Rows)
+
+	_, err = sqlite.LoadFiles("sales", []*FileDescriptor{first, third}, LoadFilesOptions{})
+	assert.EqualError(t, err, "file `"+third.Filename+"` has columns `id, qty, discount`, but `"+first.Filename+"` has `id, qty` (see LoadFilesOptions.SchemaUnion)")
+	// The table is left as is
+	assert.Equal(t, 2, countRows(t, sqlite, "sales"))
+}
+
+func TestWidenType(t *testing.T) {
+	assert.Equal(t, ColumnTypeInteger, widenType(ColumnTypeInteger, ColumnTypeInteger))
+	assert.Equal(t, ColumnTypeReal, widenType(ColumnTypeInteger, ColumnTypeReal))
+	assert.Equal(t, ColumnTypeInteger, widenType(ColumnTypeBoolean, ColumnTypeInteger))
+	assert.Equal(t, ColumnTypeText, widenType(ColumnTypeDate, ColumnTypeInteger))
+	assert.Equal(t, ColumnTypeText, widenType(ColumnTypeReal, ColumnTypeText))
+}
+
+func TestLoadFiles_GeneratedColumn(t *testing.T) {
+	sqlite := newTestDB(t)
+	withTotal := func(content string, expression string) *FileDescriptor {
+		descriptor := newTestDescriptor(writeTestCSV(t, content))
+		descriptor.Columns = []Column{{Name: "a"}, {Name: "b"}, {Name: "total", Type: ColumnTypeInteger, Expression: expression}}
+		return descriptor
+	}
+
+	stats, err := sqlite.LoadFiles("sums", []*FileDescriptor{
+		withTotal("a,b\n1,2\n", "a + b"),
+		withTotal("a,b\n3,4\n", "a + b"),
+	}, LoadFilesOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Rows)
+	// Still computed by the table, not stored
+	var total, hidden int
+	require.NoError(t, sqlite.db.QueryRow("SELECT SUM(total) FROM sums").Scan(&total))
+	assert.Equal(t, 10, total)
+	require.NoError(t, sqlite.db.QueryRow("SELECT hidden FROM pragma_table_xinfo('sums') WHERE name = 'total'").Scan(&hidden))
+	assert.Equal(t, 2, hidden)
+
+	second := withTotal("a,b\n3,4\n", "a * b")
+	_, err = sqlite.LoadFiles("sums", []*FileDescriptor{withTotal("a,b\n1,2\n", "a + b"), second}, LoadFilesOptions{})
+	assert.EqualError(t, err, "column `total` of file `"+second.Filename+"` differs from the one of the previous files, a generated column must be the same in every file")
+
+	second = newTestDescriptor(writeTestCSV(t, "a,b,total\n3,4,7\n"))
+	_, err = sqlite.LoadFiles("sums", []*FileDescriptor{withTotal("a,b\n1,2\n", "a + b"), second}, LoadFilesOptions{})
+	assert.EqualError(t, err, "column `total` of file `"+second.Filename+"` differs from the one of the previous files, a generated column must be the same in every file")
+}
+
+func TestLoadFiles_Distinct(t *testing.T) {
+	sqlite := newTestDB(t)
+	first := newTestDescriptor(writeTestCSV(t, "id,qty\n1,5\n1,5\n2,3\n"))
+	first.Distinct = true
+	// The last row of January is repeated in February
+	second := newTestDescriptor(writeTestCSV(t, "id,qty\n2,3\n3,7\n"))
+	second.Distinct = true
+
+	stats, err := sqlite.LoadFiles("sales", []*FileDescriptor{first, second}, LoadFilesOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Rows)
+	assert.Equal(t, 1, stats.Duplicates)
+
+	stats, err = sqlite.LoadFiles("sales", []*FileDescriptor{first, second}, LoadFilesOptions{Distinct: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Rows)
+	assert.Equal(t, 2, stats.Duplicates)
+	assert.Equal(t, 3, countRows(t, sqlite, "sales"))
+}
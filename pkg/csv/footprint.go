@@ -0,0 +1,94 @@
+package csv
+
+import (
+	"errors"
+	"io"
+)
+
+// Estimated data size above which EstimateFootprint recommends an on-disk DB
+const DefaultInMemoryLimit = 512 * 1024 * 1024
+
+// Count of rows sampled by SampleFootprint if not given
+const defaultFootprintSampleSize = 1000
+
+// SQLite keeps a row with the rowid, a record header (a byte per column) and a cell pointer,
+// b-tree pages are not filled up completely
+const (
+	sqliteRowOverhead = 12
+	sqlitePageFill    = 0.8
+)
+
+// FootprintEstimate is the expected size of a loaded CSV file
+type FootprintEstimate struct {
+	FileSize int64
+	// Average width (bytes) of a sampled row
+	AvgRowWidth float64
+	Rows        int64
+	// Expected size of the SQLite table
+	Bytes int64
+	// False if the table had better be stored on disk
+	InMemory bool
+}
+
+// EstimateFootprint estimates the SQLite size of a file by its size and average row width.
+// In memory storage is recommended up to memoryLimit bytes, DefaultInMemoryLimit if it is not positive.
+func EstimateFootprint(fileSize int64, avgRowWidth float64, columns int, memoryLimit int64) *FootprintEstimate {
+	if memoryLimit <= 0 {
+		memoryLimit = DefaultInMemoryLimit
+	}
+	estimate := &FootprintEstimate{FileSize: fileSize, AvgRowWidth: avgRowWidth, InMemory: true}
+	if avgRowWidth <= 0 {
+		return estimate
+	}
+	estimate.Rows = int64(float64(fileSize) / avgRowWidth)
+	// Numbers and dates are usually stored more compact than their text, so the width is an upper bound
+	rowSize := avgRowWidth + float64(columns) + sqliteRowOverhead
+	estimate.Bytes = int64(float64(estimate.Rows) * rowSize / sqlitePageFill)
+	estimate.InMemory = estimate.Bytes <= memoryLimit
+	return estimate
+}
+
+// SampleFootprint reads up to sampleSize rows of the file to measure the average row width, see EstimateFootprint
+func SampleFootprint(descriptor *FileDescriptor, sampleSize int, memoryLimit int64) (*FootprintEstimate, error) {
+	if sampleSize <= 0 {
+		sampleSize = defaultFootprintSampleSize
+	}
+	reader, err := newCsvReader(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+	if descriptor.fileSize <= 0 {
+		return nil, errors.New("the size of the file is unknown")
+	}
+
+	if !descriptor.NoHeader && !descriptor.SingleColumn {
+		if _, err := reader.csv.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	columns, width, rows := 0, 0, 0
+	for rows < sampleSize {
+		record, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) > columns {
+			columns = len(record)
+		}
+		// Delimiters and the line break
+		width += len(record)
+		for _, value := range record {
+			width += len(value)
+		}
+		rows++
+	}
+	if rows == 0 {
+		return EstimateFootprint(descriptor.fileSize, 0, columns, memoryLimit), nil
+	}
+	return EstimateFootprint(descriptor.fileSize, float64(width)/float64(rows), columns, memoryLimit), nil
+}
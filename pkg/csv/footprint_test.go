@@ -0,0 +1,32 @@
+package csv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateFootprint(t *testing.T) {
+	estimate := EstimateFootprint(1000*1000, 100, 8, 0)
+	assert.Equal(t, int64(10000), estimate.Rows)
+	assert.Equal(t, int64(10000*(100+8+sqliteRowOverhead)/sqlitePageFill), estimate.Bytes)
+	assert.True(t, estimate.InMemory)
+
+	estimate = EstimateFootprint(1000*1000, 100, 8, 1000*1000)
+	assert.False(t, estimate.InMemory)
+}
+
+func TestSampleFootprint(t *testing.T) {
+	content := "name,qty\n" + strings.Repeat("abcdefgh,123456789\n", 100)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+
+	estimate, err := SampleFootprint(descriptor, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), estimate.FileSize)
+	assert.Equal(t, 19.0, estimate.AvgRowWidth)
+	// The header is taken for a row
+	assert.Equal(t, int64(len(content)/19), estimate.Rows)
+	assert.True(t, estimate.InMemory)
+}
@@ -0,0 +1,89 @@
+package csv
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Values of Column.Grouping
+const (
+	// 1,234,567
+	GroupingWestern = "western"
+	// 12,34,567
+	GroupingIndian = "indian"
+)
+
+// At most this many warnings are kept in LoadStats.Warnings, the rest are only counted
+const maxWarnings = 100
+
+// Collects non fatal problems of a load, it is safe for concurrent use
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings []string
+	count    int
+}
+
+func (c *warningCollector) add(warning string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	if len(c.warnings) < maxWarnings {
+		c.warnings = append(c.warnings, warning)
+	}
+}
+
+func (c *warningCollector) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	warnings := append([]string(nil), c.warnings...)
+	if c.count > len(c.warnings) {
+		warnings = append(warnings, fmt.Sprintf("%d more warnings are omitted", c.count-len(c.warnings)))
+	}
+	return warnings
+}
+
+// Removes the thousands separator of a number, reporting malformed grouping (see Column.Grouping)
+func ungroupNumber(value string, column *Column, descriptor *FileDescriptor) string {
+	if column.ThousandsSeparator == "" || !strings.Contains(value, column.ThousandsSeparator) {
+		return value
+	}
+	if column.Grouping != "" && !isValidGrouping(value, column.ThousandsSeparator, column.Grouping) {
+		descriptor.warnings.add(fmt.Sprintf("value `%s` of column `%s` is not grouped as %s", value, column.Name, column.Grouping))
+	}
+	return strings.ReplaceAll(value, column.ThousandsSeparator, "")
+}
+
+func isValidGrouping(value string, separator string, grouping string) bool {
+	integerPart := strings.TrimLeft(value, "+-")
+	if i := strings.IndexAny(integerPart, ".eE"); i != -1 {
+		integerPart = integerPart[:i]
+	}
+	groups := strings.Split(integerPart, separator)
+	for i, group := range groups {
+		if group == "" || strings.Trim(group, "0123456789") != "" {
+			return false
+		}
+		last := i == len(groups)-1
+		switch {
+		case i == 0 && !last:
+			maxLen := 3
+			if grouping == GroupingIndian && len(groups) > 2 {
+				maxLen = 2
+			}
+			if len(group) > maxLen {
+				return false
+			}
+		case last && len(group) != 3:
+			return false
+		case !last && grouping == GroupingIndian && len(group) != 2:
+			return false
+		case !last && grouping != GroupingIndian && len(group) != 3:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package csv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValidGrouping(t *testing.T) {
+	tests := []struct {
+		value    string
+		grouping string
+		valid    bool
+	}{
+		{"1,234,567", GroupingWestern, true},
+		{"-12,345.75", GroupingWestern, true},
+		{"1,234,56", GroupingWestern, false},
+		{"1234,567", GroupingWestern, false},
+		{"1,23,456", GroupingWestern, false},
+		{"1,23,456", GroupingIndian, true},
+		{"12,34,567.5", GroupingIndian, true},
+		{"12,345", GroupingIndian, true},
+		{"123,456", GroupingIndian, true},
+		{"1,234,567", GroupingIndian, false},
+		{"1,234,56", GroupingIndian, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.valid, isValidGrouping(test.value, ",", test.grouping), "%s (%s)", test.value, test.grouping)
+	}
+}
+
+func TestLoadCSV_Grouping(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name;amount\nfirst;1,23,456\nsecond;1,234,56\nthird;12,34,567.5\n"))
+	descriptor.Delimiter = ';'
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "amount", Type: ColumnTypeReal, ThousandsSeparator: ",", Grouping: GroupingIndian},
+	}
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	assert.Equal(t, []string{"value `1,234,56` of column `amount` is not grouped as indian"}, stats.Warnings)
+	var amount float64
+	// Malformed, but still parsed
+	require.NoError(t, sqlite.db.QueryRow("SELECT amount FROM sales WHERE name = 'second'").Scan(&amount))
+	assert.Equal(t, 123456.0, amount)
+	require.NoError(t, sqlite.db.QueryRow("SELECT amount FROM sales WHERE name = 'third'").Scan(&amount))
+	assert.Equal(t, 1234567.5, amount)
+}
+
+func TestWarningCollector_Limit(t *testing.T) {
+	collector := &warningCollector{}
+	for i := 0; i < maxWarnings+5; i++ {
+		collector.add(fmt.Sprintf("warning %d", i))
+	}
+	warnings := collector.list()
+	assert.Len(t, warnings, maxWarnings+1)
+	assert.Equal(t, "5 more warnings are omitted", warnings[maxWarnings])
+}
@@ -0,0 +1,101 @@
+package csv
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPSource reads CSV data from an http(s) URL
+type HTTPSource struct {
+	URL string
+	// Extra request headers, e.g. Authorization
+	Header http.Header
+	// http.DefaultClient if nil
+	Client *http.Client
+}
+
+// IsHTTPURL reports whether the filename is an http(s) URL
+func IsHTTPURL(filename string) bool {
+	lower := strings.ToLower(filename)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+func (s *HTTPSource) Open() (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	// The client decompresses the body itself only if it asked for gzip, e.g. not when Accept-Encoding is set by Header
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		body, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return &gzipBody{Reader: body, body: resp.Body}, nil
+	}
+	return resp.Body, nil
+}
+
+// Size is the Content-Length (-1 if unknown). The modification time is a hash of the ETag header if present,
+// otherwise the Last-Modified header. Without either validator the data can't be told unchanged (e.g. a generated
+// or chunked response), so the current time is returned and the data is loaded again every time
+func (s *HTTPSource) Stat() (int64, int64, error) {
+	resp, err := s.do(http.MethodHead)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp.Body.Close()
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(etag))
+		return resp.ContentLength, int64(hash.Sum64()), nil
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		return resp.ContentLength, lastModified.Unix(), nil
+	}
+	return resp.ContentLength, time.Now().UnixNano(), nil
+}
+
+func (s *HTTPSource) do(method string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range s.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s %s: unexpected status `%s`", method, s.URL, resp.Status))
+	}
+	return resp, nil
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
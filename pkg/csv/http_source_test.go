@@ -0,0 +1,127 @@
+package csv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestHTTPSource_GzipEncoding(t *testing.T) {
+	content := "name,qty\nfirst,1\nsecond,2\n"
+	body := gzipped(t, content)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	// Asking for gzip explicitly turns off the transparent decompression of the client
+	source := &HTTPSource{URL: server.URL, Header: http.Header{"Accept-Encoding": []string{"gzip"}}}
+	data, err := source.Open()
+	require.NoError(t, err)
+	defer data.Close()
+	actual, err := ioutil.ReadAll(data)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(actual))
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(server.URL)
+	descriptor.Source = source
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+}
+
+func TestHTTPSource_PlainAndStat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Wed, 01 Jan 2020 10:00:00 GMT")
+		http.ServeContent(w, r, "sales.csv", time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), strings.NewReader("name\nfirst\n"))
+	}))
+	defer server.Close()
+
+	sqlite := newTestDB(t)
+	stats := mustLoadCSV(t, sqlite, "sales", newTestDescriptor(server.URL+"/sales.csv"))
+	assert.Equal(t, 1, stats.Rows)
+
+	size, modTime, err := (&HTTPSource{URL: server.URL}).Stat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+	assert.Equal(t, int64(1577872800), modTime)
+}
+
+func TestHTTPSource_UnexpectedStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := (&HTTPSource{URL: server.URL}).Open()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestHTTPSource_StatValidators(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		// Whether two Stat calls of the unchanged data return the same
+		stable bool
+	}{
+		{"etag", map[string]string{"ETag": `"v1"`}, true},
+		{"last-modified", map[string]string{"Last-Modified": "Wed, 01 Jan 2020 10:00:00 GMT"}, true},
+		{"none", map[string]string{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range test.headers {
+					w.Header().Set(name, value)
+				}
+				_, _ = w.Write([]byte("name\nfirst\n"))
+			}))
+			defer server.Close()
+
+			source := &HTTPSource{URL: server.URL}
+			_, firstModTime, err := source.Stat()
+			require.NoError(t, err)
+			_, secondModTime, err := source.Stat()
+			require.NoError(t, err)
+			assert.Equal(t, test.stable, firstModTime == secondModTime)
+
+			sqlite := newTestDB(t)
+			mustLoadCSV(t, sqlite, "sales", newTestDescriptor(server.URL))
+			stats := mustLoadCSV(t, sqlite, "sales", newTestDescriptor(server.URL))
+			assert.Equal(t, !test.stable, stats.Loaded)
+		})
+	}
+}
+
+func TestHTTPSource_StatChangedETag(t *testing.T) {
+	etag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+	}))
+	defer server.Close()
+
+	source := &HTTPSource{URL: server.URL}
+	_, before, err := source.Stat()
+	require.NoError(t, err)
+	etag = `"v2"`
+	_, after, err := source.Stat()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
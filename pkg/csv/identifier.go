@@ -0,0 +1,41 @@
+package csv
+
+import (
+	"path"
+	"strings"
+)
+
+// SanitizeIdentifier turns an arbitrary name into a plain SQL identifier:
+// characters other than letters, digits and `_` become `_` (repeats are collapsed),
+// a leading digit is prefixed with `_`. An empty result becomes `csv`.
+func SanitizeIdentifier(name string) string {
+	var sb strings.Builder
+	underscore := false
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+			underscore = false
+		} else if !underscore {
+			sb.WriteRune('_')
+			underscore = true
+		}
+	}
+	identifier := strings.Trim(sb.String(), "_")
+	if identifier == "" {
+		return "csv"
+	}
+	if identifier[0] >= '0' && identifier[0] <= '9' {
+		return "_" + identifier
+	}
+	return identifier
+}
+
+// TableNameFor derives a table name from the base name of the file (or URL) without extension,
+// e.g. /data/sales_2024.csv -> sales_2024
+func TableNameFor(filename string) string {
+	if i := strings.IndexAny(filename, "?#"); i != -1 {
+		filename = filename[:i]
+	}
+	base := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	return SanitizeIdentifier(strings.TrimSuffix(base, path.Ext(base)))
+}
@@ -0,0 +1,33 @@
+package csv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeIdentifier(t *testing.T) {
+	assert.Equal(t, "sales_2024", SanitizeIdentifier("sales_2024"))
+	assert.Equal(t, "Sales_Q1_2024", SanitizeIdentifier("Sales (Q1) 2024"))
+	assert.Equal(t, "_2024_sales", SanitizeIdentifier("2024-sales"))
+	assert.Equal(t, "a_b", SanitizeIdentifier("__a..b__"))
+	assert.Equal(t, "csv", SanitizeIdentifier("---"))
+}
+
+func TestTableNameFor(t *testing.T) {
+	assert.Equal(t, "sales_2024", TableNameFor("/data/sales_2024.csv"))
+	assert.Equal(t, "sales_2024", TableNameFor(`C:\data\sales_2024.csv`))
+	assert.Equal(t, "report_v2", TableNameFor("sftp://user@host:22/home/report v2.csv"))
+	assert.Equal(t, "export", TableNameFor("https://example.com/export.csv?token=1"))
+	assert.Equal(t, "sales_csv", TableNameFor("sales.csv.gz"))
+}
+
+func TestLoadCSV_TableNameFromFile(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name\nfirst\n"))
+
+	stats := mustLoadCSV(t, sqlite, "", descriptor)
+
+	assert.Equal(t, TableNameFor(descriptor.Filename), stats.Table)
+	assert.Equal(t, 1, countRows(t, sqlite, stats.Table))
+}
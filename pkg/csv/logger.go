@@ -0,0 +1,41 @@
+package csv
+
+import "sync"
+
+// Logger is the minimal structured logger used by the CSV DB.
+// hclog.Logger (Grafana's plugin logger) satisfies it out of the box.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+var (
+	defaultLoggerMu sync.RWMutex
+	defaultLogger   Logger = nopLogger{}
+)
+
+// SetLogger sets the logger used by DBs created without an explicit logger.
+// Passing nil restores the no-op logger.
+func SetLogger(logger Logger) {
+	defaultLoggerMu.Lock()
+	defer defaultLoggerMu.Unlock()
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	defaultLogger = logger
+}
+
+func getDefaultLogger() Logger {
+	defaultLoggerMu.RLock()
+	defer defaultLoggerMu.RUnlock()
+	return defaultLogger
+}
@@ -0,0 +1,126 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"strings"
+	"time"
+)
+
+// Bytes of rows buffered before they are written as a row group
+const parquetRowGroupSize = 16 * 1024 * 1024
+
+// ExportParquet writes the table as Parquet: integer -> INT64, real -> DOUBLE, boolean -> BOOLEAN,
+// date and timestamp -> TIMESTAMP (INT64 microseconds, UTC), anything else -> BYTE_ARRAY (UTF8).
+// Every column is optional, NULL values are kept. Rows are written by row groups, so memory is bounded by one of them.
+func (sqlite *DbSqlite) ExportParquet(w io.Writer, tableName string) error {
+	columns, err := sqlite.schema(tableName)
+	if err != nil {
+		return err
+	}
+	metadata := make([]string, 0)
+	for _, column := range columns {
+		// The writer parses the metadata as comma separated key=value pairs
+		if strings.ContainsAny(column.Name, ",=") {
+			return errors.New(fmt.Sprintf("column `%s` can not be exported to Parquet, the name contains `,` or `=`", column.Name))
+		}
+		metadata = append(metadata, fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column.Name, parquetType(column.Type)))
+	}
+	parquetWriter, err := writer.NewCSVWriterFromWriter(metadata, w, 1)
+	if err != nil {
+		return err
+	}
+	parquetWriter.RowGroupSize = parquetRowGroupSize
+
+	result, err := sqlite.Query(fmt.Sprintf("SELECT * FROM %s", quoteIdentifier(tableName)))
+	if err != nil {
+		return err
+	}
+	defer result.Release()
+
+	for {
+		values, err := result.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		row := make([]interface{}, len(values))
+		for i, value := range values {
+			row[i], err = parquetValue(value, columns[i].Type)
+			if err != nil {
+				return errors.New(fmt.Sprintf("column `%s`: %s", columns[i].Name, err.Error()))
+			}
+		}
+		if err := parquetWriter.Write(row); err != nil {
+			return err
+		}
+	}
+	return parquetWriter.WriteStop()
+}
+
+func parquetType(columnType ColumnType) string {
+	switch columnType {
+	case ColumnTypeInteger:
+		return "type=INT64"
+	case ColumnTypeReal:
+		return "type=DOUBLE"
+	case ColumnTypeBoolean:
+		return "type=BOOLEAN"
+	case ColumnTypeDate, ColumnTypeTimestamp:
+		return "type=INT64, convertedtype=TIMESTAMP_MICROS"
+	}
+	return "type=BYTE_ARRAY, convertedtype=UTF8"
+}
+
+// SQLite does not enforce column types, hence a value of another type is converted if it is lossless
+func parquetValue(value interface{}, columnType ColumnType) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch columnType {
+	case ColumnTypeInteger, ColumnTypeReal, ColumnTypeBoolean, ColumnTypeDate, ColumnTypeTimestamp:
+		// A not strict load keeps empty values of typed columns as empty strings
+		if value == "" {
+			return nil, nil
+		}
+	}
+	switch columnType {
+	case ColumnTypeInteger:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		}
+	case ColumnTypeReal:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+	case ColumnTypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		}
+	case ColumnTypeDate, ColumnTypeTimestamp:
+		switch v := value.(type) {
+		case time.Time:
+			return v.UnixNano() / int64(time.Microsecond), nil
+		case int64:
+			return v * int64(time.Second/time.Microsecond), nil
+		}
+	default:
+		return formatExportValue(value), nil
+	}
+	return nil, errors.New(fmt.Sprintf("value `%v` is not %s", value, columnType))
+}
@@ -0,0 +1,69 @@
+package csv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	parquetreader "github.com/xitongsys/parquet-go/reader"
+)
+
+func TestExportParquet(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty,price,active,day\nfirst,1,1.5,yes,2020-05-01 10:30:00\nsecond,,,,\n"))
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger},
+		{Name: "price", Type: ColumnTypeReal},
+		{Name: "active", Type: ColumnTypeBoolean},
+		{Name: "day", Type: ColumnTypeDate},
+	}
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	var out bytes.Buffer
+	require.NoError(t, sqlite.ExportParquet(&out, "sales"))
+
+	file, err := buffer.NewBufferFile(out.Bytes())
+	require.NoError(t, err)
+	parquetReader, err := parquetreader.NewParquetColumnReader(file, 1)
+	require.NoError(t, err)
+	defer parquetReader.ReadStop()
+	assert.Equal(t, int64(2), parquetReader.GetNumRows())
+
+	// The first element is the root
+	schema := parquetReader.Footer.Schema[1:]
+	expectedTypes := []parquet.Type{parquet.Type_BYTE_ARRAY, parquet.Type_INT64, parquet.Type_DOUBLE, parquet.Type_BOOLEAN, parquet.Type_INT64}
+	for i, column := range descriptor.Columns {
+		// The reader renames the columns after Go fields, ExName is the name in the file
+		assert.Equal(t, column.Name, parquetReader.SchemaHandler.Infos[i+1].ExName)
+		assert.Equal(t, expectedTypes[i], schema[i].GetType(), column.Name)
+		assert.Equal(t, parquet.FieldRepetitionType_OPTIONAL, schema[i].GetRepetitionType(), column.Name)
+	}
+	assert.Equal(t, parquet.ConvertedType_TIMESTAMP_MICROS, schema[4].GetConvertedType())
+
+	expectedValues := [][]interface{}{
+		{"first", "second"},
+		{int64(1), nil},
+		{1.5, nil},
+		{true, nil},
+		{time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC).UnixNano() / int64(time.Microsecond), nil},
+	}
+	for i := range descriptor.Columns {
+		values, _, _, err := parquetReader.ReadColumnByIndex(int64(i), 2)
+		require.NoError(t, err)
+		assert.Equal(t, expectedValues[i], values, descriptor.Columns[i].Name)
+	}
+}
+
+func TestExportParquet_InvalidColumnName(t *testing.T) {
+	sqlite := newTestDB(t)
+	mustLoadCSV(t, sqlite, "sales", newTestDescriptor(writeTestCSV(t, "\"a,b\"\n1\n")))
+
+	var out bytes.Buffer
+	err := sqlite.ExportParquet(&out, "sales")
+	assert.EqualError(t, err, "column `a,b` can not be exported to Parquet, the name contains `,` or `=`")
+}
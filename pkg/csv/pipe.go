@@ -0,0 +1,65 @@
+package csv
+
+import (
+	"io"
+	"sync"
+)
+
+// Default count of parsed records buffered between the CSV reader and the inserts
+const defaultBufferSize = 1000
+
+type rowBatch struct {
+	rows [][]interface{}
+	err  error
+}
+
+// Parses records in a separate goroutine and passes them to consume through a bounded channel.
+// The parser blocks while the inserts lag behind, so no more than bufferSize parsed records are held in memory.
+// produce returns the table rows of the next record (more than one if the record is reshaped) or io.EOF.
+func pipeRows(produce func() ([][]interface{}, error), bufferSize int, consume func([]interface{}) error) error {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	batches := make(chan rowBatch, bufferSize)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(batches)
+		for {
+			rows, err := produce()
+			if err == io.EOF {
+				return
+			}
+			select {
+			case batches <- rowBatch{rows: rows, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// The producer must be stopped before the caller closes the reader
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	for batch := range batches {
+		if batch.err != nil {
+			return batch.err
+		}
+		for _, row := range batch.rows {
+			if err := consume(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package csv
+
+import (
+	"errors"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func produceN(n int, produced *int64) func() ([][]interface{}, error) {
+	return func() ([][]interface{}, error) {
+		if atomic.LoadInt64(produced) >= int64(n) {
+			return nil, io.EOF
+		}
+		atomic.AddInt64(produced, 1)
+		return [][]interface{}{{int64(1), "some text", 1.5}}, nil
+	}
+}
+
+func TestPipeRows_Backpressure(t *testing.T) {
+	const bufferSize = 10
+	var produced, consumed, maxInFlight int64
+
+	err := pipeRows(produceN(500, &produced), bufferSize, func(row []interface{}) error {
+		// A slow insert
+		time.Sleep(100 * time.Microsecond)
+		inFlight := atomic.LoadInt64(&produced) - atomic.AddInt64(&consumed, 1)
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), consumed)
+	// The channel plus the batch being sent by the producer
+	assert.LessOrEqual(t, maxInFlight, int64(bufferSize+1))
+}
+
+func TestPipeRows_Errors(t *testing.T) {
+	var produced int64
+	produceErr := errors.New("broken record")
+	err := pipeRows(func() ([][]interface{}, error) {
+		if atomic.AddInt64(&produced, 1) > 3 {
+			return nil, produceErr
+		}
+		return [][]interface{}{{int64(1)}}, nil
+	}, 2, func(row []interface{}) error {
+		return nil
+	})
+	assert.Equal(t, produceErr, err)
+
+	consumeErr := errors.New("insert failed")
+	produced = 0
+	err = pipeRows(produceN(1000, &produced), 2, func(row []interface{}) error {
+		return consumeErr
+	})
+	assert.Equal(t, consumeErr, err)
+	// The producer has stopped rather than parsing the whole input
+	assert.Less(t, produced, int64(1000))
+}
+
+func BenchmarkPipeRows_SlowSink(b *testing.B) {
+	for _, bufferSize := range []int{10, 1000} {
+		b.Run(map[int]string{10: "buffer_10", 1000: "buffer_1000"}[bufferSize], func(b *testing.B) {
+			b.ReportAllocs()
+			var peakHeap uint64
+			for i := 0; i < b.N; i++ {
+				var produced int64
+				var stats runtime.MemStats
+				consumed := 0
+				_ = pipeRows(produceN(2000, &produced), bufferSize, func(row []interface{}) error {
+					consumed++
+					if consumed%100 == 0 {
+						runtime.ReadMemStats(&stats)
+						if stats.HeapInuse > peakHeap {
+							peakHeap = stats.HeapInuse
+						}
+					}
+					// A slow insert
+					time.Sleep(time.Microsecond)
+					return nil
+				})
+			}
+			b.ReportMetric(float64(peakHeap), "peak-heap-bytes")
+		})
+	}
+}
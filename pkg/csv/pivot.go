@@ -0,0 +1,168 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pivot turns EAV (entity, attribute, value) rows into columns, the opposite of Unpivot.
+// For example rows `(1, 'color', 'red')` and `(1, 'size', 'L')` produce the row `(1, 'red', 'L')`
+// of the table `key,color,size`. The attribute set is discovered from the data, missing values are NULL.
+type Pivot struct {
+	// Column identifying a row of the produced table
+	KeyColumn string
+	// Column holding names of the produced columns
+	AttributeColumn string
+	// Column holding the values, the produced columns have its type
+	ValueColumn string
+}
+
+func pivotStagingTable(tableName string) string {
+	return tableName + "__pivot"
+}
+
+// The wide table is built under this name and renamed once complete
+func pivotWideTable(tableName string) string {
+	return tableName + "__wide"
+}
+
+func validatePivot(pivot *Pivot, columns []Column) error {
+	for _, name := range []string{pivot.KeyColumn, pivot.AttributeColumn, pivot.ValueColumn} {
+		if indexOf(getColumnNames(columns), name) == -1 {
+			return errors.New(fmt.Sprintf("pivot: column `%s` is not found", name))
+		}
+	}
+	return nil
+}
+
+// Runs the pivot in its own transaction, e.g. out of the load transaction of a swap
+func (sqlite *DbSqlite) pivotTx(stagingTable string, tableName string, pivot *Pivot, columns []Column) (int, error) {
+	tx, err := sqlite.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	rows, err := sqlite.pivot(tx, stagingTable, tableName, pivot, columns)
+	if err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+// Creates the wide table from the staging one, which is dropped. Returns count of rows of the wide table
+func (sqlite *DbSqlite) pivot(conn execer, stagingTable string, tableName string, pivot *Pivot, columns []Column) (int, error) {
+	attributes, err := sqlite.pivotAttributes(conn, stagingTable, pivot)
+	if err != nil {
+		return 0, err
+	}
+
+	var keyType, valueType ColumnType
+	for _, column := range columns {
+		if column.Name == pivot.KeyColumn {
+			keyType = column.Type
+		}
+		if column.Name == pivot.ValueColumn {
+			valueType = column.Type
+		}
+	}
+	pivotColumns := []Column{{Name: pivot.KeyColumn, Type: keyType}}
+	selects := []string{quoteIdentifier(pivot.KeyColumn)}
+	args := make([]interface{}, 0)
+	for _, attribute := range attributes {
+		// Column names are case-insensitive
+		if strings.EqualFold(attribute, pivot.KeyColumn) {
+			return 0, errors.New(fmt.Sprintf("pivot: attribute `%s` clashes with the key column", attribute))
+		}
+		pivotColumns = append(pivotColumns, Column{Name: attribute, Type: valueType})
+		selects = append(selects, fmt.Sprintf(
+			"MAX(CASE WHEN %s = ? THEN %s END)",
+			quoteIdentifier(pivot.AttributeColumn),
+			quoteIdentifier(pivot.ValueColumn),
+		))
+		args = append(args, attribute)
+	}
+
+	wideTable := pivotWideTable(tableName)
+	if err := sqlite.exec(conn, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(wideTable))); err != nil {
+		return 0, err
+	}
+	if err := sqlite.exec(conn, createTableFor(wideTable, pivotColumns, nil)); err != nil {
+		return 0, err
+	}
+	// Keys keep the order of their first occurrence
+	query := fmt.Sprintf(
+		"INSERT INTO %s SELECT %s FROM %s GROUP BY %s ORDER BY MIN(rowid)",
+		quoteIdentifier(wideTable),
+		strings.Join(selects, ","),
+		quoteIdentifier(stagingTable),
+		quoteIdentifier(pivot.KeyColumn),
+	)
+	sqlite.logger.Debug("Execute", "sql", query)
+	result, err := conn.Exec(query, args...)
+	if err != nil {
+		sqlite.logger.Error("Execution failed", "sql", query, "error", err.Error())
+		return 0, err
+	}
+	if err := sqlite.exec(conn, fmt.Sprintf("DROP TABLE %s", quoteIdentifier(stagingTable))); err != nil {
+		return 0, err
+	}
+	// Otherwise the rename fails on views of the dropped table, as in swapTable
+	if err := sqlite.exec(conn, "PRAGMA legacy_alter_table = ON"); err != nil {
+		return 0, err
+	}
+	if err := sqlite.exec(conn, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(tableName))); err != nil {
+		return 0, err
+	}
+	if err := sqlite.exec(conn, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdentifier(wideTable), quoteIdentifier(tableName))); err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), nil
+}
+
+// Distinct attributes in the order of their first occurrence.
+// Attributes differing only by case would become the same column, so they are an error
+func (sqlite *DbSqlite) pivotAttributes(conn execer, stagingTable string, pivot *Pivot) ([]string, error) {
+	stmt, err := conn.Prepare(fmt.Sprintf(
+		"SELECT CAST(%s AS TEXT) FROM %s WHERE %s IS NOT NULL GROUP BY 1 ORDER BY MIN(rowid)",
+		quoteIdentifier(pivot.AttributeColumn),
+		quoteIdentifier(stagingTable),
+		quoteIdentifier(pivot.AttributeColumn),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attributes := make([]string, 0)
+	seen := make(map[string]string)
+	for rows.Next() {
+		var attribute string
+		if err := rows.Scan(&attribute); err != nil {
+			return nil, err
+		}
+		if other, ok := seen[strings.ToLower(attribute)]; ok {
+			return nil, errors.New(fmt.Sprintf("pivot: attributes `%s` and `%s` differ only by case, column names are case-insensitive", other, attribute))
+		}
+		seen[strings.ToLower(attribute)] = attribute
+		attributes = append(attributes, attribute)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(attributes) > defaultMaxColumns-1 {
+		return nil, errors.New(fmt.Sprintf("pivot: %d attributes, the limit is %d", len(attributes), defaultMaxColumns-1))
+	}
+	return attributes, nil
+}
@@ -0,0 +1,107 @@
+package csv
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCSV_Pivot(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "entity,attribute,value\n2,height,180\n1,height,170\n1,weight,65\n2,age,30\n"))
+	descriptor.Pivot = &Pivot{KeyColumn: "entity", AttributeColumn: "attribute", ValueColumn: "value"}
+	stats := mustLoadCSV(t, sqlite, "people", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+
+	tables, err := sqlite.tables()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"people"}, tables)
+	columns, err := sqlite.schema("people")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "entity", Type: ColumnTypeInteger},
+		{Name: "height", Type: ColumnTypeInteger},
+		{Name: "weight", Type: ColumnTypeInteger},
+		{Name: "age", Type: ColumnTypeInteger},
+	}, columns)
+
+	var entity int64
+	var height, weight, age sql.NullInt64
+	require.NoError(t, sqlite.db.QueryRow("SELECT * FROM people WHERE entity = 1").Scan(&entity, &height, &weight, &age))
+	assert.Equal(t, int64(170), height.Int64)
+	assert.Equal(t, int64(65), weight.Int64)
+	// Missing combination
+	assert.False(t, age.Valid)
+}
+
+func TestLoadCSV_PivotUnknownColumn(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "entity,attribute,value\n1,height,170\n"))
+	descriptor.Pivot = &Pivot{KeyColumn: "id", AttributeColumn: "attribute", ValueColumn: "value"}
+	_, err := sqlite.LoadCSV("people", descriptor)
+	assert.EqualError(t, err, "pivot: column `id` is not found")
+}
+
+func TestLoadCSV_PivotCaseClash(t *testing.T) {
+	sqlite := newTestDB(t)
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{"entity,attribute,value\n1,Color,red\n2,color,blue\n", "pivot: attributes `Color` and `color` differ only by case, column names are case-insensitive"},
+		{"entity,attribute,value\n1,Entity,red\n", "pivot: attribute `Entity` clashes with the key column"},
+	}
+	for _, test := range tests {
+		descriptor := newTestDescriptor(writeTestCSV(t, test.content))
+		descriptor.Pivot = &Pivot{KeyColumn: "entity", AttributeColumn: "attribute", ValueColumn: "value"}
+		_, err := sqlite.LoadCSV("items", descriptor)
+		assert.EqualError(t, err, test.err)
+	}
+	tables, err := sqlite.tables()
+	require.NoError(t, err)
+	assert.Empty(t, tables)
+}
+
+func TestLoadCSV_PivotReplaceOnReload(t *testing.T) {
+	sqlite := newTestDB(t)
+	filename := writeTestCSV(t, "entity,attribute,value\n1,height,170\n")
+	newDescriptor := func() *FileDescriptor {
+		descriptor := newTestDescriptor(filename)
+		descriptor.ReplaceOnReload = true
+		descriptor.Pivot = &Pivot{KeyColumn: "entity", AttributeColumn: "attribute", ValueColumn: "value"}
+		return descriptor
+	}
+	mustLoadCSV(t, sqlite, "people", newDescriptor())
+
+	reload := func(content string) (*LoadStats, error) {
+		require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+		modTime := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filename, modTime, modTime))
+		return sqlite.LoadCSV("people", newDescriptor())
+	}
+
+	// A failed pivot leaves the previous table as is
+	_, err := reload("entity,attribute,value\n1,Height,170\n1,height,171\n")
+	assert.EqualError(t, err, "pivot: attributes `Height` and `height` differ only by case, column names are case-insensitive")
+	tables, err := sqlite.tables()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"people"}, tables)
+	columns, err := sqlite.schema("people")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"entity", "height"}, getColumnNames(columns))
+
+	stats, err := reload("entity,attribute,value\n1,height,170\n1,weight,65\n2,height,180\n")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Rows)
+	tables, err = sqlite.tables()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"people"}, tables)
+	columns, err = sqlite.schema("people")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"entity", "height", "weight"}, getColumnNames(columns))
+}
@@ -0,0 +1,101 @@
+package csv
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ColumnStats is the profile of a column, see Dataset.Profile
+type ColumnStats struct {
+	Type ColumnType
+	// Count of non NULL values
+	Count int64
+	Nulls int64
+	// Count of distinct non NULL values, -1 if not counted
+	Distinct int64
+	// Smallest and largest values of integer, real, timestamp and date columns, nil for others or without values
+	Min interface{}
+	Max interface{}
+}
+
+// Profile returns statistics of every column of the table. Counting distinct values is optional,
+// it needs temp storage proportional to the table.
+func (d *Dataset) Profile(distinct bool) (map[string]ColumnStats, error) {
+	return d.db.profile(d.Table, distinct)
+}
+
+// All the statistics are computed by a single scan of the table
+func (sqlite *DbSqlite) profile(tableName string, distinct bool) (map[string]ColumnStats, error) {
+	columns, err := sqlite.schema(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregates := []string{"COUNT(*)"}
+	for _, column := range columns {
+		name := quoteIdentifier(column.Name)
+		aggregates = append(aggregates, fmt.Sprintf("COUNT(%s)", name))
+		if isOrderedType(column.Type) {
+			aggregates = append(aggregates, fmt.Sprintf("MIN(%s)", name), fmt.Sprintf("MAX(%s)", name))
+		}
+		if distinct {
+			aggregates = append(aggregates, fmt.Sprintf("COUNT(DISTINCT %s)", name))
+		}
+	}
+	values := make([]interface{}, len(aggregates))
+	ptrs := make([]interface{}, len(aggregates))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(aggregates, ","), quoteIdentifier(tableName))
+	sqlite.logger.Debug("Query", "sql", query)
+	if err := sqlite.db.QueryRow(query).Scan(ptrs...); err != nil {
+		return nil, err
+	}
+
+	rows := values[0].(int64)
+	stats := make(map[string]ColumnStats)
+	i := 1
+	for _, column := range columns {
+		columnStats := ColumnStats{Type: column.Type, Distinct: -1}
+		columnStats.Count = values[i].(int64)
+		columnStats.Nulls = rows - columnStats.Count
+		i++
+		if isOrderedType(column.Type) {
+			columnStats.Min = profileValue(values[i], column.Type)
+			columnStats.Max = profileValue(values[i+1], column.Type)
+			i += 2
+		}
+		if distinct {
+			columnStats.Distinct = values[i].(int64)
+			i++
+		}
+		stats[column.Name] = columnStats
+	}
+	return stats, nil
+}
+
+func isOrderedType(columnType ColumnType) bool {
+	switch columnType {
+	case ColumnTypeInteger, ColumnTypeReal, ColumnTypeTimestamp, ColumnTypeDate:
+		return true
+	}
+	return false
+}
+
+// Aggregates lose the declared type, so dates come back as text
+func profileValue(value interface{}, columnType ColumnType) interface{} {
+	text, ok := value.(string)
+	if !ok || columnType != ColumnTypeDate {
+		return value
+	}
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t.UTC()
+		}
+	}
+	return value
+}
@@ -0,0 +1,38 @@
+package csv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataset_Profile(t *testing.T) {
+	cache := NewDatasetCache(1, 0, nopLogger{})
+	defer cache.Close()
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty,price,sold_at\nfirst,1,1.5,2020-05-01\nsecond,,2.5,2020-05-03\nfirst,3,,\n"))
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger},
+		{Name: "price", Type: ColumnTypeReal},
+		{Name: "sold_at", Type: ColumnTypeDate},
+	}
+	descriptor.TypeDefaults = map[ColumnType]string{ColumnTypeInteger: TypeDefaultNull, ColumnTypeReal: TypeDefaultNull}
+	dataset, release, err := cache.Acquire("sales", descriptor)
+	require.NoError(t, err)
+	defer release()
+
+	stats, err := dataset.Profile(true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ColumnStats{
+		"name":    {Type: ColumnTypeText, Count: 3, Nulls: 0, Distinct: 2},
+		"qty":     {Type: ColumnTypeInteger, Count: 2, Nulls: 1, Distinct: 2, Min: int64(1), Max: int64(3)},
+		"price":   {Type: ColumnTypeReal, Count: 2, Nulls: 1, Distinct: 2, Min: 1.5, Max: 2.5},
+		"sold_at": {Type: ColumnTypeDate, Count: 2, Nulls: 1, Distinct: 2, Min: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), Max: time.Date(2020, 5, 3, 0, 0, 0, 0, time.UTC)},
+	}, stats)
+
+	stats, err = dataset.Profile(false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), stats["name"].Distinct)
+}
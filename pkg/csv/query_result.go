@@ -10,6 +10,9 @@ type QueryResult struct {
 	columns []string
 	ptrs []interface{}
 	vals []interface{}
+	// The first rows.Next() is already done (see DbSqlite.Query), more is its result
+	advanced bool
+	more bool
 }
 
 func newQueryResult(rows *sql.Rows) (*QueryResult, error) {
@@ -38,7 +41,16 @@ func (r *QueryResult) ColumnTypes() ([]*sql.ColumnType, error) {
 }
 
 func (r *QueryResult) Next() ([]interface{}, error) {
-	if ok := r.rows.Next(); !ok {
+	more := r.more
+	if r.advanced {
+		r.advanced = false
+	} else {
+		more = r.rows.Next()
+	}
+	if !more {
+		if err := r.rows.Err(); err != nil {
+			return nil, err
+		}
 		return nil, io.EOF
 	}
 	err := r.rows.Scan(r.ptrs...)
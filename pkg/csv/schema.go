@@ -0,0 +1,106 @@
+package csv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// SchemaProvider supplies columns of a dataset from outside of the file, e.g. from a central schema registry
+type SchemaProvider interface {
+	GetSchema(key string) ([]Column, error)
+}
+
+// HTTPSchemaProvider fetches the schema as JSON: [{"name": "qty", "type": "integer"}, ...].
+// A column without type is auto-detected.
+type HTTPSchemaProvider struct {
+	// `{key}` is replaced with the escaped key, e.g. https://registry/schemas/{key}
+	URL string
+	// http.DefaultClient if nil
+	Client *http.Client
+}
+
+type schemaColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (p *HTTPSchemaProvider) GetSchema(key string) ([]Column, error) {
+	schemaURL := strings.ReplaceAll(p.URL, "{key}", url.PathEscape(key))
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(schemaURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("schema `%s`: unexpected status `%s`", key, resp.Status))
+	}
+
+	schema := make([]schemaColumn, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&schema); err != nil {
+		return nil, errors.New(fmt.Sprintf("schema `%s`: %s", key, err.Error()))
+	}
+	if len(schema) == 0 {
+		return nil, errors.New(fmt.Sprintf("schema `%s` has no columns", key))
+	}
+
+	columns := make([]Column, 0)
+	for _, schemaColumn := range schema {
+		column := Column{Name: schemaColumn.Name}
+		if schemaColumn.Type != "" {
+			column.Type, err = ParseColumnType(schemaColumn.Type)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("schema `%s`: %s", key, err.Error()))
+			}
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// Parses a sidecar file of `name=type` lines, see FileDescriptor.TypesFile
+func readTypesFile(filename string) (map[string]ColumnType, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	types := make(map[string]ColumnType)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator == -1 {
+			return nil, errors.New(fmt.Sprintf("types file `%s`, line %d: expected `name=type`, got `%s`", filename, lineNumber, line))
+		}
+		name := strings.TrimSpace(line[:separator])
+		typeName := strings.TrimSpace(line[separator+1:])
+		if name == "" || typeName == "" {
+			return nil, errors.New(fmt.Sprintf("types file `%s`, line %d: expected `name=type`, got `%s`", filename, lineNumber, line))
+		}
+		if _, ok := types[name]; ok {
+			return nil, errors.New(fmt.Sprintf("types file `%s`, line %d: column `%s` is listed twice", filename, lineNumber, name))
+		}
+		types[name], err = ParseColumnType(typeName)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("types file `%s`, line %d: %s", filename, lineNumber, err.Error()))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
@@ -0,0 +1,134 @@
+package csv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSchemaRegistry(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/sales/v1":
+			_, _ = w.Write([]byte(`[{"name": "name", "type": "string"}, {"name": "qty", "type": "int"}, {"name": "price"}]`))
+		case "/schemas/broken":
+			_, _ = w.Write([]byte(`[{"name": "qty", "type": "money"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestHTTPSchemaProvider(t *testing.T) {
+	registry := newSchemaRegistry(t)
+	defer registry.Close()
+	provider := &HTTPSchemaProvider{URL: registry.URL + "/schemas/{key}"}
+
+	columns, err := provider.GetSchema("sales/v1")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger},
+		{Name: "price"},
+	}, columns)
+
+	_, err = provider.GetSchema("broken")
+	assert.Error(t, err)
+	_, err = provider.GetSchema("missing")
+	assert.Error(t, err)
+}
+
+func TestLoadCSV_SchemaProviderNoHeader(t *testing.T) {
+	registry := newSchemaRegistry(t)
+	defer registry.Close()
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "first,1,1.5\nsecond,2,2.5\n"))
+	descriptor.NoHeader = true
+	descriptor.SchemaProvider = &HTTPSchemaProvider{URL: registry.URL + "/schemas/{key}"}
+	descriptor.SchemaKey = "sales/v1"
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	assert.Equal(t, 2, stats.Rows)
+	assert.Equal(t, ColumnTypeReal, descriptor.Columns[2].Type)
+	var qty int64
+	require.NoError(t, sqlite.db.QueryRow("SELECT qty FROM sales WHERE name = 'first'").Scan(&qty))
+	assert.Equal(t, int64(1), qty)
+}
+
+func TestLoadCSV_NoHeaderSyntheticColumns(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "first,1,2020-05-01\nsecond,2,2020-05-02\nthird,3,2020-05-03\n"))
+	descriptor.NoHeader = true
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	assert.Equal(t, 3, stats.Rows)
+	assert.Equal(t, []string{"col1", "col2", "col3"}, getColumnNames(descriptor.Columns))
+	columns, err := sqlite.schema("sales")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "col1", Type: ColumnTypeText},
+		{Name: "col2", Type: ColumnTypeInteger},
+		{Name: "col3", Type: ColumnTypeDate},
+	}, columns)
+	var names string
+	require.NoError(t, sqlite.db.QueryRow("SELECT GROUP_CONCAT(col1) FROM sales").Scan(&names))
+	assert.Equal(t, "first,second,third", names)
+}
+
+func TestReadTypesFile(t *testing.T) {
+	filename := writeTestCSV(t, "# pinned columns\nzip = text\n\nid=string\n")
+	types, err := readTypesFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ColumnType{"zip": ColumnTypeText, "id": ColumnTypeText}, types)
+
+	filename = writeTestCSV(t, "zip=text\nid\n")
+	_, err = readTypesFile(filename)
+	assert.EqualError(t, err, "types file `"+filename+"`, line 2: expected `name=type`, got `id`")
+
+	filename = writeTestCSV(t, "zip=\n")
+	_, err = readTypesFile(filename)
+	assert.EqualError(t, err, "types file `"+filename+"`, line 1: expected `name=type`, got `zip=`")
+
+	filename = writeTestCSV(t, "zip=text\nzip=integer\n")
+	_, err = readTypesFile(filename)
+	assert.EqualError(t, err, "types file `"+filename+"`, line 2: column `zip` is listed twice")
+
+	filename = writeTestCSV(t, "zip=money\n")
+	_, err = readTypesFile(filename)
+	assert.Error(t, err)
+}
+
+func TestLoadCSV_TypesFile(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "id,zip,qty\n007,01234,5\n008,98765,6\n"))
+	descriptor.TypesFile = writeTestCSV(t, "zip=text\nid=integer\n")
+	descriptor.ColumnTypeOverrides = map[string]ColumnType{"id": ColumnTypeText}
+	descriptor.StrictSchema = true
+	mustLoadCSV(t, sqlite, "places", descriptor)
+
+	columns, err := sqlite.schema("places")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "id", Type: ColumnTypeText},
+		{Name: "zip", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger},
+	}, columns)
+	var zip string
+	require.NoError(t, sqlite.db.QueryRow("SELECT zip FROM places WHERE id = '007'").Scan(&zip))
+	assert.Equal(t, "01234", zip)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "id,zip\n1,01234\n"))
+	descriptor.ColumnTypeOverrides = map[string]ColumnType{"zip": ColumnTypeText, "zipcode": ColumnTypeText}
+	_, err = sqlite.LoadCSV("other", descriptor)
+	assert.EqualError(t, err, "type override of column `zipcode`: there is no such column in the header")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "id,zip\n1,01234\n"))
+	descriptor.Columns = []Column{{Name: "id"}}
+	descriptor.ColumnTypeOverrides = map[string]ColumnType{"zip": ColumnTypeText}
+	_, err = sqlite.LoadCSV("other", descriptor)
+	assert.EqualError(t, err, "type override of column `zip`: the column is not loaded")
+}
@@ -0,0 +1,70 @@
+package csv
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// Source provides CSV data of a FileDescriptor, e.g. a local or a remote file
+type Source interface {
+	// Opens the data stream, the loader closes it once the data is read
+	Open() (io.ReadCloser, error)
+	// Returns size and modification time (unix seconds), they are used to detect changes of the data
+	Stat() (int64, int64, error)
+}
+
+type fileSource struct {
+	filename string
+}
+
+func (s *fileSource) Open() (io.ReadCloser, error) {
+	return os.Open(s.filename)
+}
+
+func (s *fileSource) Stat() (int64, int64, error) {
+	fileStat, err := os.Stat(s.filename)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fileStat.Size(), fileStat.ModTime().Unix(), nil
+}
+
+// Data of an already open file (e.g. fs.File of embed.FS) or any other reader, it can be read only once.
+// The reader is not closed, it is owned by the caller.
+type readerSource struct {
+	reader io.Reader
+}
+
+func (s *readerSource) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(s.reader), nil
+}
+
+// Only files can be stat'ed, so a plain reader is always loaded again
+func (s *readerSource) Stat() (int64, int64, error) {
+	file, ok := s.reader.(fs.File)
+	if !ok {
+		return 0, 0, errors.New("the reader can not be stat'ed")
+	}
+	fileStat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	return fileStat.Size(), fileStat.ModTime().Unix(), nil
+}
+
+// Returns the explicit source of the descriptor, the data or http(s) URL or the local file by Filename
+func resolveSource(descriptor *FileDescriptor) Source {
+	if descriptor.Source != nil {
+		return descriptor.Source
+	}
+	if IsDataURL(descriptor.Filename) {
+		return &dataURLSource{url: descriptor.Filename}
+	}
+	if IsHTTPURL(descriptor.Filename) {
+		return &HTTPSource{URL: descriptor.Filename}
+	}
+	return &fileSource{filename: descriptor.Filename}
+}
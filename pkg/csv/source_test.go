@@ -0,0 +1,47 @@
+package csv
+
+import (
+	"embed"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//go:embed testdata/sales.csv
+var testdata embed.FS
+
+func TestLoadFrom_EmbedFS(t *testing.T) {
+	file, err := testdata.Open("testdata/sales.csv")
+	require.NoError(t, err)
+	defer file.Close()
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor("testdata/sales.csv")
+	stats, err := sqlite.LoadFrom("", file, descriptor)
+	require.NoError(t, err)
+
+	assert.Equal(t, "sales", stats.Table)
+	assert.Equal(t, 2, stats.Rows)
+	assert.Equal(t, 2, countRows(t, sqlite, "sales"))
+
+	// The descriptor is left as is, so it still loads the file rather than the exhausted reader
+	assert.Nil(t, descriptor.Source)
+	stats, err = sqlite.LoadCSV("sales_file", descriptor)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Rows)
+}
+
+func TestLoadFrom_Reader(t *testing.T) {
+	sqlite := newTestDB(t)
+	stats, err := sqlite.LoadFrom("sales", strings.NewReader("name\nfirst\n"), newTestDescriptor(""))
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Rows)
+
+	// A plain reader can not tell whether it is changed, so it is always loaded
+	stats, err = sqlite.LoadFrom("sales", strings.NewReader("name\nfirst\nsecond\n"), newTestDescriptor(""))
+	require.NoError(t, err)
+	assert.True(t, stats.Loaded)
+	assert.Equal(t, 2, countRows(t, sqlite, "sales"))
+}
@@ -1,29 +1,50 @@
 package csv
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/araddon/dateparse"
-	"github.com/hashicorp/go-hclog"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/paveldanilin/grafana-csv-plugin/pkg/model"
 	"github.com/paveldanilin/grafana-csv-plugin/pkg/util"
 	"io"
+	"math"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type DbSqlite struct {
 	db *sql.DB
-	logger hclog.Logger
+	// Held from Init till Close, so the in-memory DB outlives idle connections; swaps of reloaded tables run on it
+	keepAlive *sql.Conn
+	swapMu    sync.Mutex
+	logger Logger
+}
+
+// Either the DB or a load transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
 }
 
 const metaCsvTable = "_meta_csv_"
 
-// If maxIdleCons <= 0, no idle connections are retained
+// Count of data rows checked by FileDescriptor.StrictSchema and for booleans
+const detectionSampleSize = 100
+
+// SQLITE_MAX_COLUMN
+const defaultMaxColumns = 2000
+
+// If maxIdleCons <= 0, no idle connections are retained (the DB itself is kept by a dedicated connection, see Init)
 // If connMaxLifetime <= 0, connections are reused forever.
-func NewDB(maxIdleCons int, connMaxLifetime time.Duration, logger hclog.Logger) (DB, error) {
+// If logger is nil, the package logger (see SetLogger) is used.
+func NewDB(maxIdleCons int, connMaxLifetime time.Duration, logger Logger) (DB, error) {
 	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
 	if err != nil {
 		return nil, err
@@ -32,46 +53,153 @@ func NewDB(maxIdleCons int, connMaxLifetime time.Duration, logger hclog.Logger)
 	db.SetMaxIdleConns(maxIdleCons)
 	db.SetConnMaxLifetime(connMaxLifetime)
 
+	if logger == nil {
+		logger = getDefaultLogger()
+	}
+
 	return &DbSqlite{db: db, logger: logger}, nil
 }
 
 func (sqlite *DbSqlite) Init() error {
 	sqlite.logger.Debug("Init CSV DB")
+	if sqlite.keepAlive == nil {
+		conn, err := sqlite.db.Conn(context.Background())
+		if err != nil {
+			return err
+		}
+		sqlite.keepAlive = conn
+	}
 	return sqlite.createMetaCsvTable()
 }
 
-func (sqlite *DbSqlite) Query(sql string) (*QueryResult, error) {
-	sqlite.logger.Debug("Query", "sql", sql)
-	rows, err := sqlite.db.Query(sql)
+func (sqlite *DbSqlite) Query(query string) (*QueryResult, error) {
+	sqlite.logger.Debug("Query", "sql", query)
+	var rows *sql.Rows
+	more := false
+	// A concurrent schema change (e.g. a swap of a reloaded table) locks the schema or the tables of the shared cache
+	// for a moment. The tables are locked by the first step, so it is retried along with the query
+	err := retryLocked(func() error {
+		var err error
+		rows, err = sqlite.db.Query(query)
+		if err != nil {
+			return err
+		}
+		more = rows.Next()
+		if err := rows.Err(); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		return nil
+	})
 	if err != nil {
 		sqlite.logger.Error("Query failed", "error", err.Error())
 		return nil, err
 	}
-	return newQueryResult(rows)
+	result, err := newQueryResult(rows)
+	if err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	result.advanced = true
+	result.more = more
+	return result, nil
+}
+
+// Snapshot writes the DB to a new SQLite file using VACUUM INTO. An existing file is never overwritten
+func (sqlite *DbSqlite) Snapshot(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.New(fmt.Sprintf("snapshot file `%s` already exists", path))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	sqlite.logger.Debug("Snapshot", "path", path)
+	if _, err := sqlite.db.Exec("VACUUM INTO ?", path); err != nil {
+		sqlite.logger.Error("Snapshot failed", "path", path, "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (sqlite *DbSqlite) Close() error {
+	if sqlite.keepAlive != nil {
+		_ = sqlite.keepAlive.Close()
+	}
+	return sqlite.db.Close()
+}
+
+// LoadFrom loads already open data, e.g. fs.File of embed.FS. Neither seeking nor closing of the reader is done.
+// descriptor.Filename is used only for naming (see TableNameFor) and logging. The load works on a copy of the descriptor,
+// so the descriptor can be reused, e.g. to load the file itself.
+func (sqlite *DbSqlite) LoadFrom(tableName string, r io.Reader, descriptor *FileDescriptor) (*LoadStats, error) {
+	descriptor = cloneDescriptor(descriptor)
+	descriptor.Source = &readerSource{reader: r}
+	return sqlite.LoadCSV(tableName, descriptor)
+}
+
+// If tableName is empty, it is derived from the file name, see TableNameFor
+func (sqlite *DbSqlite) LoadCSV(tableName string, descriptor *FileDescriptor) (*LoadStats, error) {
+	return sqlite.LoadCSVContext(context.Background(), tableName, descriptor)
+}
+
+// LoadCSVContext is LoadCSV which stops inserting once the context is done, nothing is loaded then.
+// The returned error is the one of the context (context.Canceled or context.DeadlineExceeded)
+func (sqlite *DbSqlite) LoadCSVContext(ctx context.Context, tableName string, descriptor *FileDescriptor) (*LoadStats, error) {
+	stats, err := sqlite.loadCSV(ctx, tableName, descriptor)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return stats, nil
+}
+
+// go-sqlite3 reports a statement stopped by the context as interrupted, and database/sql rolls back
+// the transaction of a done context, so the following statements fail with sql.ErrTxDone
+func contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrInterrupt {
+		return ctxErr
+	}
+	if errors.Is(err, ctxErr) || errors.Is(err, sql.ErrTxDone) {
+		return ctxErr
+	}
+	return err
 }
 
-func (sqlite *DbSqlite) LoadCSV(tableName string, descriptor *FileDescriptor) error {
+func (sqlite *DbSqlite) loadCSV(ctx context.Context, tableName string, descriptor *FileDescriptor) (*LoadStats, error) {
+	if tableName == "" {
+		tableName = TableNameFor(descriptor.Filename)
+	}
 	sqlite.logger.Info("Loading CSV", "table", tableName, "filename", descriptor.Filename)
+	loadStarted := time.Now()
+	resetDateLayouts(descriptor.Columns)
 
 	var metaCsv *model.Meta
 	reload := false
 	tableExists, err := sqlite.ifTableExists(tableName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if tableExists {
 		metaCsv = sqlite.getMetaCsv(tableName)
 		if metaCsv == nil {
-			sqlite.logger.Debug("CSV already loaded", "table", tableName, "filename", descriptor.Filename, "meta", "nil", "reload", false)
-			return nil
+			// E.g. created by another DB sharing the in-memory cache, nothing tells whether it is up to date
+			sqlite.logger.Debug("Table exists without meta", "table", tableName, "filename", descriptor.Filename, "reload", true)
 		}
+	}
 
-		fSize, fModTime := util.FileStat(descriptor.Filename)
-		if fSize == metaCsv.FileSize && fModTime == metaCsv.FileModTime {
+	if metaCsv != nil {
+		fSize, fModTime, err := resolveSource(descriptor).Stat()
+		if err != nil {
+			// Let the reader report the actual problem
+			sqlite.logger.Warn("Failed to stat CSV", "table", tableName, "filename", descriptor.Filename, "error", err.Error())
+		}
+		if err == nil && fSize == metaCsv.FileSize && fModTime == metaCsv.FileModTime {
 			// the file is not changed
 			sqlite.logger.Debug("CSV already loaded", "table", tableName, "filename", descriptor.Filename, "changed", false, "reload", false)
-			return nil
+			return &LoadStats{Table: tableName}, nil
 		}
 
 		// The file is changed, we should reload it
@@ -81,54 +209,409 @@ func (sqlite *DbSqlite) LoadCSV(tableName string, descriptor *FileDescriptor) er
 		metaCsv.FileModTime = fModTime
 	}
 
+	descriptor.warnings = &warningCollector{}
 	reader, err := newCsvReader(descriptor)
 	if err != nil {
 		sqlite.logger.Debug("Failed to create CSV reader", "error", err.Error(), "filename", descriptor.Filename)
-		return err
+		return nil, err
 	}
 	defer reader.close()
 
-	if reload {
-		_ = sqlite.updateMetaCsv(metaCsv)
-	} else {
-		_ = sqlite.createMetaCsv(&model.Meta{
+	header, firstRow, columnsMap, err := readColumns(reader, descriptor, sqlite.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	tableColumns := descriptor.Columns
+	toRows := func(values []string) ([][]interface{}, error) {
+		rowValues, err := valuesToRow(values, descriptor, columnsMap)
+		if err != nil {
+			return nil, err
+		}
+		return [][]interface{}{rowValues}, nil
+	}
+	if descriptor.Unpivot != nil {
+		unpivot, err := newUnpivotTransform(descriptor, columnsMap)
+		if err != nil {
+			return nil, err
+		}
+		tableColumns = unpivot.columns
+		toRows = unpivot.toRows
+	}
+	if descriptor.AddLoadTimestamp {
+		// Not mapped to the header, every row gets the same time
+		loadedAt := loadStarted.UTC()
+		column := Column{Name: loadTimestampColumnName(descriptor), Type: ColumnTypeDate}
+		if indexOf(getColumnNames(tableColumns), column.Name) != -1 {
+			return nil, errors.New(fmt.Sprintf("load timestamp column `%s` clashes with a CSV column", column.Name))
+		}
+		tableColumns = append(tableColumns[:len(tableColumns):len(tableColumns)], column)
+		rowsOf := toRows
+		toRows = func(values []string) ([][]interface{}, error) {
+			rows, err := rowsOf(values)
+			if err != nil {
+				return nil, err
+			}
+			for i := range rows {
+				rows[i] = append(rows[i], loadedAt)
+			}
+			return rows, nil
+		}
+	}
+	if err := sqlite.validateExpressions(tableColumns, header); err != nil {
+		return nil, err
+	}
+	// Generated columns are neither inserted nor compared by Distinct
+	tableColumnNames := make([]string, 0)
+	for _, column := range tableColumns {
+		if !column.isGenerated() {
+			tableColumnNames = append(tableColumnNames, column.Name)
+		}
+	}
+
+	// On a swap the table is built aside and renamed once complete
+	swap := reload && descriptor.ReplaceOnReload
+	targetTable := tableName
+	if swap {
+		targetTable = reloadTable(tableName)
+	}
+
+	// A pivot is built from the rows loaded into a staging table
+	loadTable := targetTable
+	if descriptor.Pivot != nil {
+		if descriptor.Unpivot != nil {
+			return nil, errors.New("pivot and unpivot can not be combined")
+		}
+		if err := validatePivot(descriptor.Pivot, tableColumns); err != nil {
+			return nil, err
+		}
+		loadTable = pivotStagingTable(targetTable)
+	}
+
+	// Changing the schema locks it for every connection of the shared cache till the end of the transaction,
+	// hence on a swap the table is created upfront and the long load transaction only inserts into it
+	if swap {
+		err := retryLocked(func() error {
+			if err := sqlite.exec(sqlite.db, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(loadTable))); err != nil {
+				return err
+			}
+			return sqlite.exec(sqlite.db, createTableFor(loadTable, tableColumns, descriptor.TypeDefaults))
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = sqlite.exec(sqlite.db, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(loadTable)))
+		}()
+	}
+
+	// Nothing (neither the table nor its meta) is changed unless the whole file is loaded
+	tx, err := sqlite.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// On a swap the meta is updated along with the rename
+	if reload && !swap {
+		if err := sqlite.updateMetaCsv(tx, metaCsv); err != nil {
+			return nil, err
+		}
+	} else if !reload {
+		err := sqlite.createMetaCsv(tx, &model.Meta{
 			TableName:   tableName,
 			FileName:    descriptor.Filename,
 			FileSize:    descriptor.fileSize,
 			FileModTime: descriptor.fileModTime,
 		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The table is recreated, so a reload picks up changed columns too
+	if !swap {
+		if err := sqlite.exec(tx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(loadTable))); err != nil {
+			return nil, err
+		}
+		if err := sqlite.exec(tx, createTableFor(loadTable, tableColumns, descriptor.TypeDefaults)); err != nil {
+			return nil, err
+		}
 	}
 
-	// NewRead header
-	// TODO: we should somehow handle the situation when there is no header line
-	header, err := reader.csv.Read()
+	// Prepare INSERT statement
+	sqlInsert := createInsertFor(loadTable, tableColumnNames)
+	stmt, err := tx.Prepare(sqlInsert)
 	if err != nil {
-		sqlite.logger.Error("Failed to read the header line", "error", err.Error(), "filename", descriptor.Filename)
-		return err
+		return nil, err
+	}
+	defer stmt.Close()
+
+	sqlite.logger.Debug("Begin inserting", "table", tableName, "filename", descriptor.Filename)
+	insertedCount := 0
+	rowNumber := 0
+
+	// Insert rows starting from the first one...
+	reader.unread(firstRow)
+	produce := func() ([][]interface{}, error) {
+		row, err := reader.read()
+		if err != nil {
+			return nil, err
+		}
+		rowNumber++
+		if err := checkRowLength(row, header, descriptor); err != nil {
+			return nil, rowError(rowNumber, err)
+		}
+
+		// CSV Row -> Insert values
+		rowsValues, err := toRows(row)
+		if err != nil {
+			return nil, rowError(rowNumber, err)
+		}
+		return rowsValues, nil
+	}
+	err = pipeRows(produce, descriptor.BufferSize, func(rowValues []interface{}) error {
+		if _, err := stmt.ExecContext(ctx, rowValues...); err != nil {
+			// The transaction of a done context is rolled back concurrently, closing the statement
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		insertedCount++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.verifyChecksum(descriptor.ExpectedChecksum); err != nil {
+		sqlite.logger.Error("Checksum verification failed", "table", tableName, "error", err.Error(), "filename", descriptor.Filename)
+		return nil, err
+	}
+
+	sqlite.logger.Debug("Stop inserting", "table", tableName, "inserted", insertedCount, "filename", descriptor.Filename)
+
+	stats := &LoadStats{Table: tableName, Loaded: true, Rows: insertedCount, Warnings: descriptor.warnings.list(), RawPrefix: reader.rawBytes()}
+	if len(stats.Warnings) > 0 {
+		sqlite.logger.Warn("CSV has been loaded with warnings", "table", tableName, "warnings", len(stats.Warnings), "first", stats.Warnings[0], "filename", descriptor.Filename)
+	}
+	if descriptor.Distinct {
+		duplicates, err := sqlite.removeDuplicates(tx, loadTable, tableColumnNames)
+		if err != nil {
+			return nil, err
+		}
+		stats.Duplicates = duplicates
+		stats.Rows -= duplicates
+		sqlite.logger.Info("Duplicate rows have been removed", "table", tableName, "duplicates", duplicates, "filename", descriptor.Filename)
+	}
+	// Like the creation of the table, the pivot of a swap changes the schema out of the load transaction
+	pivotAfterCommit := swap && descriptor.Pivot != nil
+	if descriptor.Pivot != nil && !pivotAfterCommit {
+		_ = stmt.Close()
+		rows, err := sqlite.pivot(tx, loadTable, targetTable, descriptor.Pivot, tableColumns)
+		if err != nil {
+			return nil, err
+		}
+		stats.Rows = rows
+	}
+	// On a swap the statements run after the rename, so they refer to the new table
+	if !swap {
+		if err := sqlite.execPostLoadSQL(tx, descriptor.PostLoadSQL); err != nil {
+			return nil, err
+		}
+		if err := sqlite.analyze(tx, targetTable, descriptor.Analyze); err != nil {
+			return nil, err
+		}
+	}
+	if !pivotAfterCommit {
+		if err := checkExpectedRows(descriptor, stats.Rows); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if pivotAfterCommit {
+		_ = stmt.Close()
+		err := retryLocked(func() error {
+			rows, err := sqlite.pivotTx(loadTable, targetTable, descriptor.Pivot, tableColumns)
+			stats.Rows = rows
+			return err
+		})
+		if err == nil {
+			err = checkExpectedRows(descriptor, stats.Rows)
+		}
+		if err != nil {
+			_ = sqlite.exec(sqlite.db, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(targetTable)))
+			return nil, err
+		}
+	}
+	if swap {
+		swapIn := func() error {
+			return sqlite.swapTable(targetTable, tableName, metaCsv, descriptor)
+		}
+		if err := retryLocked(swapIn); err != nil {
+			_ = sqlite.exec(sqlite.db, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(targetTable)))
+			return nil, err
+		}
+	}
+	if reader.skipped > 0 {
+		sqlite.logger.Warn("Empty records have been skipped", "table", tableName, "skipped", reader.skipped, "filename", descriptor.Filename)
+	}
+	sqlite.logger.Info("CSV has been loaded", "table", tableName, "filename", descriptor.Filename, "rows", stats.Rows, "reload", reload, "elapsed", time.Since(loadStarted).String())
+
+	return stats, nil
+}
+
+// Reads the header (with the units and types rows) and the first data row, then defines the columns of the descriptor:
+// provided, overridden or detected. Returns the header, the first data row (it is still to be converted)
+// and the map of column names to their CSV column indexes
+func readColumns(reader *reader, descriptor *FileDescriptor, logger Logger) ([]string, []string, map[string]int, error) {
+	if len(descriptor.Columns) == 0 && descriptor.SchemaProvider != nil {
+		columns, err := descriptor.SchemaProvider.GetSchema(descriptor.SchemaKey)
+		if err != nil {
+			logger.Error("Failed to get the schema", "key", descriptor.SchemaKey, "error", err.Error(), "filename", descriptor.Filename)
+			return nil, nil, nil, err
+		}
+		descriptor.Columns = columns
+	}
+
+	var header, unitsRow, typesRow []string
+	var err error
+	if descriptor.SingleColumn {
+		// Every line is a data row of the only TEXT column
+		header = []string{singleColumnName(descriptor)}
+		descriptor.Columns = []Column{{Type: ColumnTypeText, Name: header[0]}}
+	} else {
+		if descriptor.NoHeader {
+			if len(descriptor.Columns) > 0 && !hasColumnIndexes(descriptor.Columns) {
+				// The columns follow each other in the order of the definition
+				header = getColumnNames(descriptor.Columns)
+			} else {
+				// col1..colN, the first row is returned back to be inserted as data
+				firstRow, err := reader.read()
+				if err != nil {
+					logger.Error("Failed to read the first data line", "error", err.Error(), "filename", descriptor.Filename)
+					return nil, nil, nil, err
+				}
+				reader.unread(firstRow)
+				header = syntheticHeader(len(firstRow))
+			}
+		} else {
+			header, err = reader.csv.Read()
+			if err != nil {
+				logger.Error("Failed to read the header line", "error", err.Error(), "filename", descriptor.Filename)
+				return nil, nil, nil, err
+			}
+			// E.g. a leading line of delimiters only, the columns would have no names
+			if isEmptyRecord(header) {
+				return nil, nil, nil, errors.New("the header line has only empty cells, is there a leading blank line?")
+			}
+			if descriptor.ExpectedHeader != nil {
+				if err := compareHeader(descriptor.ExpectedHeader, header, descriptor.IgnoreHeaderOrder); err != nil {
+					logger.Error("Unexpected header", "error", err.Error(), "filename", descriptor.Filename)
+					return nil, nil, nil, err
+				}
+			}
+		}
+		maxColumns := descriptor.MaxColumns
+		if maxColumns <= 0 {
+			maxColumns = defaultMaxColumns
+		}
+		if len(header) > maxColumns {
+			return nil, nil, nil, errors.New(fmt.Sprintf("CSV has %d columns, the limit is %d (is the delimiter right?)", len(header), maxColumns))
+		}
+
+		// Rows below the header must be consumed before the first data row, so they never poison type detection or get inserted
+		if descriptor.UnitsRow {
+			unitsRow, err = reader.csv.Read()
+			if err != nil {
+				logger.Error("Failed to read the units line", "error", err.Error(), "filename", descriptor.Filename)
+				return nil, nil, nil, err
+			}
+		}
+
+		if descriptor.FirstRowIsTypes {
+			typesRow, err = reader.csv.Read()
+			if err != nil {
+				logger.Error("Failed to read the types line", "error", err.Error(), "filename", descriptor.Filename)
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	if hasColumnIndexes(descriptor.Columns) {
+		header, err = applyColumnIndexes(header, descriptor.Columns)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 	}
 
 	// Auto detect column types by the first row with data
 	// Keep in mind that in case the absence of data the type will be detected incorrectly
 	// In such edge situations, it would be better explicitly define column-type at the data source settings page
-	firstRow, err := reader.csv.Read()
+	firstRow, err := reader.read()
 	if err != nil {
-		sqlite.logger.Error("Failed to read the first data line", "error", err.Error(), "filename", descriptor.Filename)
-		return err
+		logger.Error("Failed to read the first data line", "error", err.Error(), "filename", descriptor.Filename)
+		return nil, nil, nil, err
+	}
+	if (descriptor.Columns == nil || len(descriptor.Columns) == 0) && typesRow != nil {
+		columns, err := columnsFromTypesRow(header, typesRow)
+		if err != nil {
+			logger.Error("Failed to parse the types line", "error", err.Error(), "filename", descriptor.Filename)
+			return nil, nil, nil, err
+		}
+		descriptor.Columns = columns
+	}
+	if err := checkRowLength(firstRow, header, descriptor); err != nil {
+		return nil, nil, nil, rowError(1, err)
 	}
 	if descriptor.Columns == nil || len(descriptor.Columns) == 0 {
-		columnTypesStr := make([]string, 0)
 		descriptor.Columns = make([]Column, 0)
-		for i, firstRowVal := range firstRow {
-			columnName := header[i]
-			columnType := detectDatatype(firstRowVal)
-			descriptor.Columns = append(descriptor.Columns, Column{
-				Type: columnType,
-				Name: columnName,
-			})
-			columnTypesStr = append(columnTypesStr, fmt.Sprintf("[%s](%s)", columnName, columnType))
+		for i := range firstRow {
+			descriptor.Columns = append(descriptor.Columns, Column{Name: header[i]})
 		}
+	}
 
-		sqlite.logger.Info("CSV column types have been auto-detected", "filename", descriptor.Filename, "columns", strings.Join(columnTypesStr, ","))
+	if !descriptor.SingleColumn {
+		overrides, err := columnTypeOverrides(descriptor)
+		if err != nil {
+			logger.Error("Failed to read the column types", "error", err.Error(), "filename", descriptor.Filename)
+			return nil, nil, nil, err
+		}
+		if err := applyColumnTypeOverrides(descriptor.Columns, header, overrides); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Columns without an explicit type are auto-detected
+	moreRows, err := reader.peek(detectionSampleSize - 1)
+	if err != nil {
+		logger.Error("Failed to read the sample lines", "error", err.Error(), "filename", descriptor.Filename)
+		return nil, nil, nil, err
+	}
+	// Empty records (blank rows in the middle) would make every column text
+	sample := make([][]string, 0)
+	for _, row := range append([][]string{firstRow}, moreRows...) {
+		if !isEmptyRecord(row) {
+			sample = append(sample, row)
+		}
+	}
+	if len(sample) == 0 {
+		sample = append(sample, firstRow)
+	}
+	columnTypesStr, err := detectColumnTypes(descriptor.Columns, header, sample, descriptor.StrictSchema)
+	if err != nil {
+		logger.Error("Failed to detect column types", "error", err.Error(), "filename", descriptor.Filename)
+		return nil, nil, nil, err
+	}
+	if len(columnTypesStr) > 0 {
+		logger.Info("CSV column types have been auto-detected", "filename", descriptor.Filename, "columns", strings.Join(columnTypesStr, ","))
+	}
+
+	if err := validateDefaultDates(descriptor.Columns); err != nil {
+		return nil, nil, nil, err
 	}
 
 	// Build map: ColumnName -> CSV column Id
@@ -142,63 +625,164 @@ func (sqlite *DbSqlite) LoadCSV(tableName string, descriptor *FileDescriptor) er
 		}
 	}
 
-	if reload {
-		if err := sqlite.exec(fmt.Sprintf("DELETE FROM %s", tableName)); err != nil {
-			return err
-		}
-	} else {
-		if err := sqlite.exec(createTableFor(tableName, descriptor.Columns)); err != nil {
-			return err
+	if unitsRow != nil {
+		for i := range descriptor.Columns {
+			columnIndex, ok := columnsMap[descriptor.Columns[i].Name]
+			if ok && columnIndex < len(unitsRow) && descriptor.Columns[i].Unit == "" {
+				descriptor.Columns[i].Unit = strings.TrimSpace(unitsRow[columnIndex])
+			}
 		}
 	}
 
-	// Prepare INSERT statement
-	sqlInsert := createInsertFor(tableName, csvColumns)
-	stmt, err := sqlite.db.Prepare(sqlInsert)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
-	sqlite.logger.Debug("Begin inserting", "table", tableName, "filename", descriptor.Filename)
-	insertedCount := 1
+	return header, firstRow, columnsMap, nil
+}
 
-	// Insert the first row
-	rowValues := valuesToRow(firstRow, descriptor.Columns, columnsMap)
-	_, err = stmt.Exec(rowValues...)
-	if err != nil {
-		return err
+// Compiles every expression against the columns, so a misspelled column is reported before anything is loaded
+func (sqlite *DbSqlite) validateExpressions(columns []Column, header []string) error {
+	selects := make([]string, 0)
+	for _, column := range columns {
+		selects = append(selects, fmt.Sprintf("NULL AS %s", quoteIdentifier(column.Name)))
 	}
-
-	// Insert rows...
-	for {
-		row, err := reader.csv.Read()
-		if err != nil && err != io.EOF {
-			return err
+	for _, column := range columns {
+		if !column.isGenerated() {
+			continue
 		}
+		if indexOf(header, column.Name) != -1 {
+			return errors.New(fmt.Sprintf("generated column `%s` clashes with a CSV column", column.Name))
+		}
+		stmt, err := sqlite.db.Prepare(fmt.Sprintf("SELECT (%s) FROM (SELECT %s)", column.Expression, strings.Join(selects, ",")))
+		if err != nil {
+			return errors.New(fmt.Sprintf("generated column `%s`: %s", column.Name, err.Error()))
+		}
+		_ = stmt.Close()
+	}
+	return nil
+}
 
-		if err == io.EOF {
-			break
+func (sqlite *DbSqlite) execPostLoadSQL(conn execer, statements []string) error {
+	for i, statement := range statements {
+		if err := sqlite.exec(conn, statement); err != nil {
+			return errors.New(fmt.Sprintf("post-load SQL statement %d: %s", i+1, err.Error()))
 		}
+	}
+	return nil
+}
 
-		// CSV Row -> Insert values
-		rowValues := valuesToRow(row, descriptor.Columns, columnsMap)
-		_, err = stmt.Exec(rowValues...)
+// Gathers statistics of the table and its indexes for the query planner, see FileDescriptor.Analyze
+func (sqlite *DbSqlite) analyze(conn execer, tableName string, analyze *bool) error {
+	if analyze == nil {
+		stmt, err := conn.Prepare("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND tbl_name=?")
 		if err != nil {
 			return err
 		}
+		defer stmt.Close()
+		indexes := 0
+		if err := stmt.QueryRow(tableName).Scan(&indexes); err != nil {
+			return err
+		}
+		if indexes == 0 {
+			return nil
+		}
+	} else if !*analyze {
+		return nil
+	}
+	return sqlite.exec(conn, fmt.Sprintf("ANALYZE %s", quoteIdentifier(tableName)))
+}
 
-		insertedCount++
+func checkExpectedRows(descriptor *FileDescriptor, rows int) error {
+	if descriptor.ExpectedRows != nil && *descriptor.ExpectedRows != rows {
+		return errors.New(fmt.Sprintf("row count mismatch: expected %d, loaded %d", *descriptor.ExpectedRows, rows))
 	}
+	return nil
+}
 
-	sqlite.logger.Debug("Stop inserting", "table", tableName, "inserted", insertedCount, "filename", descriptor.Filename)
+func reloadTable(tableName string) string {
+	return tableName + "__reload"
+}
 
-	return nil
+// Attempts of a schema change failed because a concurrent query holds the shared cache lock
+const lockedRetries = 50
+
+// The shared cache does not wait for locks (no busy timeout), so a short statement is retried instead
+func retryLocked(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < lockedRetries; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if sqliteErr, ok := err.(sqlite3.Error); !ok || sqliteErr.Code != sqlite3.ErrLocked {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Millisecond)
+	}
+	return err
+}
+
+// Replaces tableName by the completely loaded newTable in a single transaction,
+// so concurrent queries see either the old or the new table, but never a missing one
+func (sqlite *DbSqlite) swapTable(newTable string, tableName string, meta *model.Meta, descriptor *FileDescriptor) error {
+	sqlite.swapMu.Lock()
+	defer sqlite.swapMu.Unlock()
+
+	tx, err := sqlite.keepAlive.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if err := sqlite.updateMetaCsv(tx, meta); err != nil {
+		return err
+	}
+	// Otherwise the rename fails on views of the dropped table instead of leaving them to the new one
+	if err := sqlite.exec(tx, "PRAGMA legacy_alter_table = ON"); err != nil {
+		return err
+	}
+	if err := sqlite.exec(tx, fmt.Sprintf("DROP TABLE IF EXISTS %s", quoteIdentifier(tableName))); err != nil {
+		return err
+	}
+	if err := sqlite.exec(tx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", quoteIdentifier(newTable), quoteIdentifier(tableName))); err != nil {
+		return err
+	}
+	if err := sqlite.execPostLoadSQL(tx, descriptor.PostLoadSQL); err != nil {
+		return err
+	}
+	if err := sqlite.analyze(tx, tableName, descriptor.Analyze); err != nil {
+		return err
+	}
+	sqlite.logger.Debug("Reloaded table has been swapped in", "table", tableName)
+	return tx.Commit()
 }
 
-func (sqlite *DbSqlite) exec(sql string) error {
+// Keeps the first occurrence of every distinct row.
+// The grouping is done by SQLite itself, hence memory is bounded by its temp storage rather than by a Go map of row hashes.
+func (sqlite *DbSqlite) removeDuplicates(conn execer, tableName string, columnNames []string) (int, error) {
+	quotedNames := make([]string, 0)
+	for _, columnName := range columnNames {
+		quotedNames = append(quotedNames, quoteIdentifier(columnName))
+	}
+	query := fmt.Sprintf(
+		"DELETE FROM %s WHERE rowid NOT IN (SELECT MIN(rowid) FROM %s GROUP BY %s)",
+		quoteIdentifier(tableName),
+		quoteIdentifier(tableName),
+		strings.Join(quotedNames, ","),
+	)
+	sqlite.logger.Debug("Execute", "sql", query)
+	result, err := conn.Exec(query)
+	if err != nil {
+		sqlite.logger.Error("Execution failed", "sql", query, "error", err.Error())
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
+
+func (sqlite *DbSqlite) exec(conn execer, sql string) error {
 	sqlite.logger.Debug("Execute", "sql", sql)
-	_, err := sqlite.db.Exec(sql)
+	_, err := conn.Exec(sql)
 	if err != nil {
 		sqlite.logger.Error("Execution failed", "sql", sql, "error", err.Error())
 		return err
@@ -226,6 +810,55 @@ func (sqlite *DbSqlite) ifTableExists(tableName string) (bool, error) {
 	return count > 0, nil
 }
 
+// Names of the user tables, internal SQLite tables and the meta table are skipped
+func (sqlite *DbSqlite) tables() ([]string, error) {
+	rows, err := sqlite.db.Query(
+		"SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' AND name != ? ORDER BY name",
+		metaCsvTable,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+func (sqlite *DbSqlite) schema(tableName string) ([]Column, error) {
+	// Unlike table_info, table_xinfo lists generated columns as well
+	rows, err := sqlite.db.Query(fmt.Sprintf("PRAGMA table_xinfo(%s)", quoteIdentifier(tableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make([]Column, 0)
+	for rows.Next() {
+		var cid, notNull, pk, hidden int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk, &hidden); err != nil {
+			return nil, err
+		}
+		columns = append(columns, Column{Name: name, Type: ColumnType(strings.ToLower(columnType))})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, errors.New(fmt.Sprintf("table `%s` does not exist", tableName))
+	}
+	return columns, nil
+}
+
 func (sqlite *DbSqlite) createMetaCsvTable() error {
 	metaColumns := make([]Column, 0)
 	metaColumns = append(metaColumns, Column{
@@ -244,7 +877,7 @@ func (sqlite *DbSqlite) createMetaCsvTable() error {
 		Type: "INTEGER",
 		Name: "file_mod_time",
 	})
-	if err := sqlite.exec(createTableFor(metaCsvTable, metaColumns)); err != nil {
+	if err := sqlite.exec(sqlite.db, createTableFor(metaCsvTable, metaColumns, nil)); err != nil {
 		return err
 	}
 	return nil
@@ -286,8 +919,8 @@ func (sqlite *DbSqlite) getMetaCsv(tableName string) *model.Meta {
 }
 
 // TODO: move to Repository
-func (sqlite *DbSqlite) updateMetaCsv(meta *model.Meta) error {
-	return sqlite.exec(
+func (sqlite *DbSqlite) updateMetaCsv(conn execer, meta *model.Meta) error {
+	return sqlite.exec(conn,
 		fmt.Sprintf(
 			"UPDATE %s SET file_size=%d, file_mod_time=%d WHERE table_name='%s'",
 			metaCsvTable,
@@ -299,8 +932,8 @@ func (sqlite *DbSqlite) updateMetaCsv(meta *model.Meta) error {
 }
 
 // TODO: move to Repository
-func (sqlite *DbSqlite) createMetaCsv(meta *model.Meta) error {
-	return sqlite.exec(
+func (sqlite *DbSqlite) createMetaCsv(conn execer, meta *model.Meta) error {
+	return sqlite.exec(conn,
 		fmt.Sprintf(
 			"INSERT INTO %s VALUES('%s', '%s', %d, %d)",
 			metaCsvTable,
@@ -312,35 +945,98 @@ func (sqlite *DbSqlite) createMetaCsv(meta *model.Meta) error {
 	)
 }
 
-func createTableFor(tableName string, columns []Column) string {
+func (sqlite *DbSqlite) deleteMetaCsv(conn execer, tableName string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE table_name = ?", metaCsvTable)
+	sqlite.logger.Debug("Execute", "sql", query)
+	if _, err := conn.Exec(query, tableName); err != nil {
+		sqlite.logger.Error("Execution failed", "sql", query, "error", err.Error())
+		return err
+	}
+	return nil
+}
+
+func createTableFor(tableName string, columns []Column, typeDefaults map[ColumnType]string) string {
 	columnDefs := make([]string, 0)
 
 	for _, column := range columns {
+		if column.isGenerated() {
+			// column data_type AS (expression) VIRTUAL
+			columnDefs = append(columnDefs, fmt.Sprintf("%s %s AS (%s) VIRTUAL", quoteIdentifier(column.Name), column.Type, column.Expression))
+			continue
+		}
 		columnDefs = append(columnDefs,
 			// column data_type DEFAULT 0
-			fmt.Sprintf("%s %s %s", column.Name, column.Type, getDefaultForColumn(column.Type)),
+			fmt.Sprintf("%s %s %s", quoteIdentifier(column.Name), column.Type, getDefaultForColumn(column, typeDefaults)),
 		)
 	}
 
-	return fmt.Sprintf("CREATE TABLE %s(%s)", tableName, strings.Join(columnDefs, ","))
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(%s)", quoteIdentifier(tableName), strings.Join(columnDefs, ","))
 }
 
-func getDefaultForColumn(columnType ColumnType) string {
-	switch columnType {
-	case ColumnTypeReal:
-		return "DEFAULT 0"
-	case ColumnTypeInteger:
-		return "DEFAULT 0"
+func getDefaultForColumn(column Column, typeDefaults map[ColumnType]string) string {
+	if column.Type == ColumnTypeDate && column.DefaultDate != "" {
+		if t, err := dateparse.ParseAny(column.DefaultDate); err == nil {
+			return fmt.Sprintf("DEFAULT %s", quoteLiteral(t.Format(sqlite3.SQLiteTimestampFormats[0])))
+		}
+	}
+	if column.EmptyAsZero && isNumericType(column.Type) {
+		return fmt.Sprintf("DEFAULT %s", zeroLiteral(column.Type))
+	}
+	if typeDefault, ok := typeDefaults[column.Type]; ok {
+		switch typeDefault {
+		case TypeDefaultNull:
+			return ""
+		case TypeDefaultZero:
+			return fmt.Sprintf("DEFAULT %s", zeroLiteral(column.Type))
+		}
+		return fmt.Sprintf("DEFAULT %s", quoteLiteral(typeDefault))
+	}
+
+	switch column.Type {
+	case ColumnTypeInteger, ColumnTypeReal:
+		// NULL unless EmptyAsZero, 0 would skew aggregates
+		return ""
 	case ColumnTypeText:
 		return "DEFAULT \"\""
 	case ColumnTypeDate:
-		return "DEFAULT CURRENT_TIMESTAMP"
+		// Nullable, the load time is a misleading default for missing dates
+		return ""
 	case ColumnTypeTimestamp:
-		return "DEFAULT CURRENT_TIMESTAMP"
+		return ""
 	}
 	return "DEFAULT 0"
 }
 
+// Zero value of the column type, see TypeDefaultZero
+func zeroValue(columnType ColumnType) interface{} {
+	switch columnType {
+	case ColumnTypeInteger, ColumnTypeTimestamp:
+		return int64(0)
+	case ColumnTypeReal:
+		return float64(0)
+	case ColumnTypeDate:
+		return time.Unix(0, 0).UTC()
+	case ColumnTypeBoolean:
+		return false
+	}
+	return ""
+}
+
+func zeroLiteral(columnType ColumnType) string {
+	switch v := zeroValue(columnType).(type) {
+	case time.Time:
+		return quoteLiteral(v.Format(sqlite3.SQLiteTimestampFormats[0]))
+	case string:
+		return quoteLiteral(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func quoteLiteral(value string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", "''"))
+}
+
 func getColumnNames(columns []Column) []string {
 	columnNames := make([]string, 0)
 	for _, column := range columns {
@@ -351,18 +1047,262 @@ func getColumnNames(columns []Column) []string {
 
 func createInsertFor(tableName string, columnNames []string) string {
 	binds := strings.TrimSuffix(strings.Repeat("?,", len(columnNames)), ",")
-	return fmt.Sprintf("INSERT INTO %s (%s) values(%s)", tableName, strings.Join(columnNames, ","), binds)
+	quotedNames := make([]string, 0)
+	for _, columnName := range columnNames {
+		quotedNames = append(quotedNames, quoteIdentifier(columnName))
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) values(%s)", quoteIdentifier(tableName), strings.Join(quotedNames, ","), binds)
+}
+
+// Header names may contain spaces, delimiters or quotes, so identifiers are always quoted
+func quoteIdentifier(name string) string {
+	return fmt.Sprintf("\"%s\"", strings.ReplaceAll(name, "\"", "\"\""))
+}
+
+func hasColumnIndexes(columns []Column) bool {
+	for _, column := range columns {
+		if column.Index != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Puts the names of the columns with Index at their positions, so the rest of the load matches them by name.
+// Other cells of the same names are cleared, a column is never matched by both
+func applyColumnIndexes(header []string, columns []Column) ([]string, error) {
+	names := make(map[string]bool)
+	positions := make(map[int]string)
+	for _, column := range columns {
+		if column.Index == 0 {
+			continue
+		}
+		if column.Index < 0 || column.Index > len(header) {
+			return nil, errors.New(fmt.Sprintf("index %d of column `%s` is out of range, the file has %d columns", column.Index, column.Name, len(header)))
+		}
+		if other, ok := positions[column.Index]; ok {
+			return nil, errors.New(fmt.Sprintf("columns `%s` and `%s` have the same index %d", other, column.Name, column.Index))
+		}
+		positions[column.Index] = column.Name
+		names[column.Name] = true
+	}
+	indexed := make([]string, len(header))
+	for i, name := range header {
+		if !names[name] {
+			indexed[i] = name
+		}
+	}
+	for index, name := range positions {
+		indexed[index-1] = name
+	}
+	return indexed, nil
+}
+
+func syntheticHeader(size int) []string {
+	header := make([]string, size)
+	for i := range header {
+		header[i] = fmt.Sprintf("col%d", i+1)
+	}
+	return header
+}
+
+// Reports columns missing in the header and unexpected ones, or the order if it matters
+func compareHeader(expected []string, header []string, ignoreOrder bool) error {
+	missing := make([]string, 0)
+	for _, name := range expected {
+		if indexOf(header, name) == -1 {
+			missing = append(missing, name)
+		}
+	}
+	unexpected := make([]string, 0)
+	for _, name := range header {
+		if indexOf(expected, name) == -1 {
+			unexpected = append(unexpected, name)
+		}
+	}
+
+	diff := make([]string, 0)
+	if len(missing) > 0 {
+		diff = append(diff, fmt.Sprintf("missing [%s]", strings.Join(missing, ", ")))
+	}
+	if len(unexpected) > 0 {
+		diff = append(diff, fmt.Sprintf("unexpected [%s]", strings.Join(unexpected, ", ")))
+	}
+	if len(diff) == 0 && !ignoreOrder && strings.Join(expected, "\x00") != strings.Join(header, "\x00") {
+		diff = append(diff, fmt.Sprintf("expected order [%s], got [%s]", strings.Join(expected, ", "), strings.Join(header, ", ")))
+	}
+	if len(diff) > 0 {
+		return errors.New(fmt.Sprintf("header does not match the expected one: %s", strings.Join(diff, "; ")))
+	}
+	return nil
 }
 
-func getColumnType(columns []Column, columnName string) *ColumnType {
+func validateDefaultDates(columns []Column) error {
 	for _, column := range columns {
-		if column.Name == columnName {
-			return &column.Type
+		if column.DefaultDate == "" {
+			continue
+		}
+		if _, err := dateparse.ParseAny(column.DefaultDate); err != nil {
+			return errors.New(fmt.Sprintf("invalid default date `%s` of column `%s`: %s", column.DefaultDate, column.Name, err.Error()))
+		}
+	}
+	return nil
+}
+
+// Builds columns from the header and the row declaring the column types (see ParseColumnType)
+func columnsFromTypesRow(header []string, typesRow []string) ([]Column, error) {
+	if len(typesRow) != len(header) {
+		return nil, errors.New(fmt.Sprintf("types row has %d fields, but header has %d", len(typesRow), len(header)))
+	}
+	columns := make([]Column, 0)
+	for i, typeName := range typesRow {
+		columnType, err := ParseColumnType(typeName)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("column `%s`: %s", header[i], err.Error()))
+		}
+		columns = append(columns, Column{
+			Type: columnType,
+			Name: header[i],
+		})
+	}
+	return columns, nil
+}
+
+// ColumnTypeOverrides merged over the TypesFile entries
+func columnTypeOverrides(descriptor *FileDescriptor) (map[string]ColumnType, error) {
+	if descriptor.TypesFile == "" {
+		return descriptor.ColumnTypeOverrides, nil
+	}
+	overrides, err := readTypesFile(descriptor.TypesFile)
+	if err != nil {
+		return nil, err
+	}
+	for name, columnType := range descriptor.ColumnTypeOverrides {
+		overrides[name] = columnType
+	}
+	return overrides, nil
+}
+
+// Pins the types of the overridden columns, so they are not detected
+func applyColumnTypeOverrides(columns []Column, header []string, overrides map[string]ColumnType) error {
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	// The first error is the same on every load
+	sort.Strings(names)
+	columnNames := getColumnNames(columns)
+	for _, name := range names {
+		if indexOf(header, name) == -1 {
+			return errors.New(fmt.Sprintf("type override of column `%s`: there is no such column in the header", name))
+		}
+		i := indexOf(columnNames, name)
+		if i == -1 {
+			return errors.New(fmt.Sprintf("type override of column `%s`: the column is not loaded", name))
 		}
+		columns[i].Type = overrides[name]
 	}
 	return nil
 }
 
+// Sets the type of each column without an explicit type by its values in the sample rows.
+// Without strict only the first row is taken into account, with strict every non empty sampled value
+// must have the same type, otherwise an error naming the column and the conflicting values is returned.
+// Booleans are detected by the whole sample in both modes, see isBooleanSample. Returns descriptions of the detected columns.
+func detectColumnTypes(columns []Column, header []string, sample [][]string, strict bool) ([]string, error) {
+	columnTypesStr := make([]string, 0)
+	for i := range columns {
+		// Without a type, a generated column has the type of its expression
+		if columns[i].Type != "" || columns[i].isGenerated() {
+			continue
+		}
+		columnIndex := indexOf(header, columns[i].Name)
+		if columnIndex == -1 {
+			columns[i].Type = ColumnTypeText
+			continue
+		}
+
+		if isBooleanSample(sample, columnIndex, &columns[i]) {
+			columns[i].Type = ColumnTypeBoolean
+		} else if !strict {
+			value := ""
+			if columnIndex < len(sample[0]) {
+				value = columns[i].trim(sample[0][columnIndex])
+			}
+			columns[i].Type = detectColumnDatatype(&columns[i], value)
+		} else {
+			var firstValue string
+			for _, row := range sample {
+				if columnIndex >= len(row) {
+					continue
+				}
+				value := columns[i].trim(row[columnIndex])
+				if value == "" {
+					continue
+				}
+				columnType := detectColumnDatatype(&columns[i], value)
+				if columns[i].Type == "" {
+					columns[i].Type = columnType
+					firstValue = value
+				} else if columns[i].Type != columnType {
+					return nil, errors.New(fmt.Sprintf(
+						"ambiguous type of column `%s`: `%s` is %s, but `%s` is %s",
+						columns[i].Name, firstValue, columns[i].Type, value, columnType,
+					))
+				}
+			}
+			if columns[i].Type == "" {
+				columns[i].Type = ColumnTypeText
+			}
+		}
+		columnTypesStr = append(columnTypesStr, fmt.Sprintf("[%s](%s)", columns[i].Name, columns[i].Type))
+	}
+	return columnTypesStr, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// Values like 0x1F are integers only if the column has IntBase, otherwise they are text
+func detectColumnDatatype(column *Column, value string) ColumnType {
+	if column.ThousandsSeparator != "" {
+		value = strings.ReplaceAll(value, column.ThousandsSeparator, "")
+	}
+	if column.IntBase != 0 && column.IntBase != 10 {
+		if _, err := parseInt(value, column.IntBase); err == nil {
+			return ColumnTypeInteger
+		}
+	}
+	return detectDatatype(value)
+}
+
+// Booleans are detected only from the whole sample: every value is true/false/yes/no or 0/1 and at least
+// one of them is a word, so neither a 0/1 count nor a country column starting with NO is taken for flags
+func isBooleanSample(sample [][]string, columnIndex int, column *Column) bool {
+	words := false
+	for _, row := range sample {
+		if columnIndex >= len(row) {
+			continue
+		}
+		value := column.trim(row[columnIndex])
+		switch strings.ToLower(value) {
+		case "":
+		case "0", "1":
+		case "true", "false", "yes", "no":
+			words = true
+		default:
+			return false
+		}
+	}
+	return words
+}
+
 // Caveat: function is not able to guess timestamp format, it will always be Integer
 func detectDatatype(value string) ColumnType {
 	if util.IsNumber(value) {
@@ -378,48 +1318,230 @@ func detectDatatype(value string) ColumnType {
 	return ColumnTypeText
 }
 
-func valuesToRow(values []string, columns []Column, columnsMap map[string]int) []interface{} {
+func valuesToRow(values []string, descriptor *FileDescriptor, columnsMap map[string]int) ([]interface{}, error) {
 	rowValues := make([]interface{}, 0)
+	columns := descriptor.Columns
 
-	for _, column := range columns {
-		if columnIndex, ok := columnsMap[column.Name]; ok {
-			columnType := getColumnType(columns, column.Name)
-			rowValues = append(rowValues, strToValue(values[columnIndex], columnType))
+	for i := range columns {
+		if columnIndex, ok := columnsMap[columns[i].Name]; ok {
+			value, err := fieldToValue(values, columnIndex, &columns[i], descriptor)
+			if err != nil {
+				return nil, err
+			}
+			rowValues = append(rowValues, value)
 		}
 	}
 
-	return rowValues
+	return rowValues, nil
+}
+
+// A field omitted by a short row (see FileDescriptor.AllowShortRows) is NULL, a present one is converted by strToValue
+func fieldToValue(values []string, index int, column *Column, descriptor *FileDescriptor) (interface{}, error) {
+	if index >= len(values) {
+		return nil, nil
+	}
+	return strToValue(values[index], column, descriptor)
 }
 
-func strToValue(value string, columnType *ColumnType) interface{} {
-	if columnType == nil {
-		return value
+// Converts a CSV value to the column type.
+// If the value can not be represented exactly in the column type, the raw string is returned,
+// unless descriptor.Strict is set: then an error is returned.
+// The value is trimmed (Column.TrimCutset, then FileDescriptor.TrimSpace) and becomes NULL if it is one of
+// Column.NullValues (or FileDescriptor.NullValues) or blank (FileDescriptor.NullifyBlank).
+// Otherwise an empty value becomes the column default date, the type default (see FileDescriptor.TypeDefaults)
+// or NULL in columns other than TEXT (for numbers unless Column.EmptyAsZero).
+// NUL bytes are stripped or rejected beforehand, see FileDescriptor.NulBytes.
+func strToValue(value string, column *Column, descriptor *FileDescriptor) (interface{}, error) {
+	if column == nil {
+		return value, nil
+	}
+	if strings.IndexByte(value, 0) != -1 {
+		if descriptor.NulBytes == NulBytesReject {
+			return nil, errors.New(fmt.Sprintf("value of column `%s` contains a NUL byte", column.Name))
+		}
+		descriptor.warnings.add(fmt.Sprintf("NUL bytes are stripped from the value of column `%s`", column.Name))
+		value = strings.ReplaceAll(value, "\x00", "")
+	}
+	value = column.trim(value)
+	if descriptor.TrimSpace {
+		value = strings.TrimSpace(value)
 	}
-	switch *columnType {
+	nullValues := column.NullValues
+	if len(nullValues) == 0 {
+		nullValues = descriptor.NullValues
+	}
+	if indexOf(nullValues, value) != -1 {
+		return nil, nil
+	}
+	// Whitespace is data in a TEXT column unless it is trimmed
+	if descriptor.NullifyBlank && (value == "" || (column.Type != ColumnTypeText && strings.TrimSpace(value) == "")) {
+		return nil, nil
+	}
+	if value == "" {
+		if column.Type == ColumnTypeDate && column.DefaultDate != "" {
+			value = column.DefaultDate
+		} else if column.EmptyAsZero && isNumericType(column.Type) {
+			return zeroValue(column.Type), nil
+		} else if typeDefault, ok := descriptor.TypeDefaults[column.Type]; ok {
+			switch typeDefault {
+			case TypeDefaultNull:
+				return nil, nil
+			case TypeDefaultZero:
+				return zeroValue(column.Type), nil
+			}
+			value = typeDefault
+		} else if column.Type != ColumnTypeText {
+			return nil, nil
+		}
+	}
+	if len(column.AllowedValues) > 0 && value != "" && !column.isAllowed(value) {
+		message := fmt.Sprintf("value `%s` of column `%s` is not one of the allowed values", value, column.Name)
+		if descriptor.Strict {
+			return nil, errors.New(message)
+		}
+		descriptor.warnings.add(message)
+	}
+	switch column.Type {
 	case ColumnTypeDate:
-		t, err := dateparse.ParseAny(value)
+		t, err := parseDate(value, column)
 		if err != nil {
-			return value
+			return invalidValue(value, column, descriptor.Strict)
+		}
+		if column.TruncateTo > 0 {
+			t = t.Truncate(column.TruncateTo)
 		}
-		return t
+		return t, nil
 	case ColumnTypeTimestamp:
 		ival, err := strconv.ParseInt(value, 10, 64)
 		if err != nil {
-			return value
+			return invalidValue(value, column, descriptor.Strict)
+		}
+		// Unix seconds
+		if step := int64(column.TruncateTo / time.Second); step > 1 {
+			remainder := ival % step
+			if remainder < 0 {
+				// Before 1970 the time is rounded down as well
+				remainder += step
+			}
+			ival -= remainder
 		}
-		return ival
+		return ival, nil
 	case ColumnTypeInteger:
-		ival, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			return value
+		number := ungroupNumber(value, column, descriptor)
+		ival, err := parseInt(number, column.IntBase)
+		if err == nil {
+			return ival, checkRange(float64(ival), value, column, descriptor)
+		}
+		if descriptor.Strict {
+			// 3.0 is still an exact integer, 3.14 is not
+			fval, err := strconv.ParseFloat(number, 64)
+			if err == nil && fval == math.Trunc(fval) && fval >= math.MinInt64 && fval < math.MaxInt64 {
+				return int64(fval), checkRange(fval, value, column, descriptor)
+			}
+		}
+		return invalidValue(value, column, descriptor.Strict)
+	case ColumnTypeBoolean:
+		switch strings.ToLower(value) {
+		case "true", "t", "yes", "y", "1":
+			return true, nil
+		case "false", "f", "no", "n", "0":
+			return false, nil
 		}
-		return ival
+		return invalidValue(value, column, descriptor.Strict)
 	case ColumnTypeReal:
-		fval, err := strconv.ParseFloat(value, 64)
+		fval, err := strconv.ParseFloat(ungroupNumber(value, column, descriptor), 64)
 		if err != nil {
-			return value
+			return invalidValue(value, column, descriptor.Strict)
 		}
-		return fval
+		return fval, checkRange(fval, value, column, descriptor)
+	}
+	return value, nil
+}
+
+// A number out of Column.Min/Column.Max fails the load in strict mode, otherwise it is stored with a warning
+func checkRange(number float64, value string, column *Column, descriptor *FileDescriptor) error {
+	var message string
+	if column.Min != nil && number < *column.Min {
+		message = fmt.Sprintf("value `%s` of column `%s` is less than the minimum %g", value, column.Name, *column.Min)
+	} else if column.Max != nil && number > *column.Max {
+		message = fmt.Sprintf("value `%s` of column `%s` is greater than the maximum %g", value, column.Name, *column.Max)
+	} else {
+		return nil
 	}
-	return value
+	if descriptor.Strict {
+		return errors.New(message)
+	}
+	descriptor.warnings.add(message)
+	return nil
+}
+
+// Base 0 means decimal unless the value has 0x, 0o or 0b prefix (010 is still 10, not 8).
+// Other bases accept the matching prefix too, e.g. both 1F and 0x1F with base 16.
+func parseInt(value string, base int) (int64, error) {
+	digits := strings.TrimLeft(value, "+-")
+	prefix := ""
+	if len(digits) > 2 && digits[0] == '0' {
+		prefix = strings.ToLower(digits[:2])
+	}
+	prefixBase := map[string]int{"0x": 16, "0o": 8, "0b": 2}[prefix]
+	if base == 0 && prefixBase == 0 {
+		base = 10
+	} else if base != 0 && base == prefixBase {
+		// Keep the sign only
+		value = value[:len(value)-len(digits)] + digits[2:]
+	}
+	return strconv.ParseInt(value, base, 64)
+}
+
+// Tries the column layouts in order and falls back to guessing the format
+func parseDate(value string, column *Column) (time.Time, error) {
+	for _, layout := range column.Formats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	// ParseAny is slow, the layout it has recognized is reused while it fits the values
+	if column.dateLayout != "" {
+		if t, err := time.Parse(column.dateLayout, value); err == nil {
+			return t, nil
+		}
+	}
+	t, err := dateparse.ParseAny(value)
+	if err != nil {
+		return t, err
+	}
+	column.dateLayout = ""
+	if layout, err := dateparse.ParseFormat(value); err == nil {
+		// E.g. unix timestamps have no layout
+		if layoutTime, err := time.Parse(layout, value); err == nil && layoutTime.Equal(t) {
+			column.dateLayout = layout
+		}
+	}
+	return t, nil
+}
+
+// The layouts recognized by parseDate are kept in the columns of the descriptor, another load starts afresh
+func resetDateLayouts(columns []Column) {
+	for i := range columns {
+		columns[i].dateLayout = ""
+	}
+}
+
+func invalidValue(value string, column *Column, strict bool) (interface{}, error) {
+	if strict {
+		return nil, errors.New(fmt.Sprintf("value `%s` of column `%s` is not a valid %s", value, column.Name, column.Type))
+	}
+	return value, nil
+}
+
+// Rows are as long as the header unless FileDescriptor.AllowShortRows, then a longer one would lose its extra fields
+func checkRowLength(row []string, header []string, descriptor *FileDescriptor) error {
+	if descriptor.AllowShortRows && len(row) > len(header) {
+		return errors.New(fmt.Sprintf("the row has %d fields, but the header has %d", len(row), len(header)))
+	}
+	return nil
+}
+
+func rowError(rowNumber int, err error) error {
+	return errors.New(fmt.Sprintf("row %d: %s", rowNumber, err.Error()))
 }
@@ -0,0 +1,1163 @@
+package csv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Each test gets its own shared in-memory DB, so table names never collide between tests
+func newTestDB(t *testing.T) *DbSqlite {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := sql.Open("sqlite3", dsn)
+	require.NoError(t, err)
+
+	sqlite := &DbSqlite{db: db, logger: nopLogger{}}
+	t.Cleanup(func() {
+		_ = sqlite.Close()
+	})
+	require.NoError(t, sqlite.Init())
+	return sqlite
+}
+
+func writeTestCSV(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "csv_test_*.csv")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.Remove(file.Name())
+	})
+	_, err = file.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	return file.Name()
+}
+
+func newTestDescriptor(filename string) *FileDescriptor {
+	return &FileDescriptor{
+		Filename:  filename,
+		Delimiter: ',',
+		Comment:   '#',
+	}
+}
+
+func mustLoadCSV(t *testing.T, sqlite *DbSqlite, tableName string, descriptor *FileDescriptor) *LoadStats {
+	stats, err := sqlite.LoadCSV(tableName, descriptor)
+	require.NoError(t, err)
+	return stats
+}
+
+func countRows(t *testing.T, sqlite *DbSqlite, tableName string) int {
+	var count int
+	require.NoError(t, sqlite.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&count))
+	return count
+}
+
+func TestLoadCSV_FirstRowIsTypes(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "id,name,price\ninteger,text,real\n1,apple,1.5\n2,pear,2.25\n"))
+	descriptor.FirstRowIsTypes = true
+
+	mustLoadCSV(t, sqlite, "products", descriptor)
+	assert.Equal(t, 2, countRows(t, sqlite, "products"))
+	assert.Equal(t, []Column{
+		{Type: ColumnTypeInteger, Name: "id"},
+		{Type: ColumnTypeText, Name: "name"},
+		{Type: ColumnTypeReal, Name: "price"},
+	}, descriptor.Columns)
+
+	var typeRows int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM products WHERE name = 'text' OR id = 'integer' OR price = 'real'").Scan(&typeRows))
+	assert.Equal(t, 0, typeRows)
+
+	var firstName string
+	require.NoError(t, sqlite.db.QueryRow("SELECT name FROM products WHERE id = 1").Scan(&firstName))
+	assert.Equal(t, "apple", firstName)
+}
+
+func TestLoadCSV_FirstRowIsTypesNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown_type", "id,name\ninteger,blob\n1,apple\n", "column `name`: unknown column type `blob`, expected one of: text, integer, real, boolean, date, timestamp"},
+		{"alias", "id,name\nint,string\n1,apple\n", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlite := newTestDB(t)
+			descriptor := newTestDescriptor(writeTestCSV(t, test.content))
+			descriptor.FirstRowIsTypes = true
+
+			_, err := sqlite.LoadCSV("products", descriptor)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestLoadCSV_DefaultDate(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultDate string
+		expected    sql.NullTime
+		err         string
+	}{
+		{"default_date", "1970-01-01", sql.NullTime{Time: time.Unix(0, 0).UTC(), Valid: true}, ""},
+		// An empty date is NULL, never the load time
+		{"no_default_date", "", sql.NullTime{}, ""},
+		{"invalid_default_date", "not a date", sql.NullTime{}, "invalid default date `not a date` of column `created`"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlite := newTestDB(t)
+			descriptor := newTestDescriptor(writeTestCSV(t, "id,created\n1,2020-05-01\n2,\n"))
+			descriptor.Columns = []Column{
+				{Type: ColumnTypeInteger, Name: "id"},
+				{Type: ColumnTypeDate, Name: "created", DefaultDate: test.defaultDate},
+			}
+
+			_, err := sqlite.LoadCSV("events", descriptor)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			var created sql.NullTime
+			require.NoError(t, sqlite.db.QueryRow("SELECT created FROM events WHERE id = 2").Scan(&created))
+			assert.Equal(t, test.expected, created)
+		})
+	}
+}
+
+func TestStrToValue_Strict(t *testing.T) {
+	integer := &Column{Type: ColumnTypeInteger, Name: "qty"}
+	tests := []struct {
+		name     string
+		column   *Column
+		strict   bool
+		value    string
+		expected interface{}
+		err      string
+	}{
+		{"kept_without_strict", integer, false, "3.14", "3.14", ""},
+		{"invalid_integer", integer, true, "3.14", nil, "value `3.14` of column `qty` is not a valid integer"},
+		{"integral_real", integer, true, "3.0", int64(3), ""},
+		{"empty_integer", integer, true, "", nil, ""},
+		{"invalid_real", &Column{Type: ColumnTypeReal, Name: "price"}, true, "abc", nil, "value `abc` of column `price` is not a valid real"},
+		// Strict rejects values, it does not change how empty strings are stored
+		{"empty_text", &Column{Type: ColumnTypeText, Name: "note"}, true, "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := strToValue(test.value, test.column, &FileDescriptor{Strict: test.strict})
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, value)
+		})
+	}
+}
+
+func TestLoadCSV_Strict(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "id,qty\n1,3\n2,3.14\n"))
+	descriptor.Strict = true
+
+	_, err := sqlite.LoadCSV("items", descriptor)
+	assert.EqualError(t, err, "row 2: value `3.14` of column `qty` is not a valid integer")
+}
+
+func TestLoadCSV_QuotedHeaderWithDelimiter(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "\"last, first\",age\n\"Doe, John\",42\n"))
+
+	mustLoadCSV(t, sqlite, "people", descriptor)
+	assert.Equal(t, []Column{
+		{Type: ColumnTypeText, Name: "last, first"},
+		{Type: ColumnTypeInteger, Name: "age"},
+	}, descriptor.Columns)
+
+	var name string
+	var age int
+	require.NoError(t, sqlite.db.QueryRow(`SELECT "last, first", age FROM people`).Scan(&name, &age))
+	assert.Equal(t, "Doe, John", name)
+	assert.Equal(t, 42, age)
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"name"`, quoteIdentifier("name"))
+	assert.Equal(t, `"last, first"`, quoteIdentifier("last, first"))
+	assert.Equal(t, `"say ""hi"""`, quoteIdentifier(`say "hi"`))
+}
+
+func TestLoadCSV_Distinct(t *testing.T) {
+	content := "id,name\n1,a\n2,b\n1,a\n3,\n3,\n1,a\n"
+
+	sqlite := newTestDB(t)
+	stats := mustLoadCSV(t, sqlite, "all_rows", newTestDescriptor(writeTestCSV(t, content)))
+	assert.Equal(t, 6, stats.Rows)
+	assert.Equal(t, 0, stats.Duplicates)
+
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Distinct = true
+	stats = mustLoadCSV(t, sqlite, "distinct_rows", descriptor)
+	assert.Equal(t, 3, stats.Rows)
+	assert.Equal(t, 3, stats.Duplicates)
+	assert.Equal(t, 3, countRows(t, sqlite, "distinct_rows"))
+}
+
+func TestStrToValue_DateFormats(t *testing.T) {
+	column := &Column{Type: ColumnTypeDate, Name: "day", Formats: []string{"02.01.2006", "2006/02/01"}}
+	strict := &FileDescriptor{Strict: true}
+
+	value, err := strToValue("25.12.2020", column, strict)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 12, 25, 0, 0, 0, 0, time.UTC), value)
+
+	// Ambiguous for guessing (month first), but the declared layout puts the day first
+	value, err = strToValue("2020/03/04", column, strict)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 4, 3, 0, 0, 0, 0, time.UTC), value)
+
+	// Falls back to guessing
+	value, err = strToValue("2020-05-01", column, strict)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), value)
+}
+
+func TestLoadCSV_UnitsRow(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "sensor,speed,mass\n,m/s,kg\ns1,12.5,3\ns2,13,4\n"))
+	descriptor.UnitsRow = true
+
+	stats := mustLoadCSV(t, sqlite, "measurements", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+	assert.Equal(t, []Column{
+		{Type: ColumnTypeText, Name: "sensor"},
+		{Type: ColumnTypeReal, Name: "speed", Unit: "m/s"},
+		{Type: ColumnTypeInteger, Name: "mass", Unit: "kg"},
+	}, descriptor.Columns)
+}
+
+func TestLoadCSV_StrictSchema(t *testing.T) {
+	content := "id,code\n1,100\n2,\n3,A-7\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.StrictSchema = true
+	_, err := sqlite.LoadCSV("codes", descriptor)
+	assert.EqualError(t, err, "ambiguous type of column `code`: `100` is integer, but `A-7` is text")
+
+	// An explicit type overrides detection
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.StrictSchema = true
+	descriptor.Columns = []Column{{Name: "id"}, {Name: "code", Type: ColumnTypeText}}
+	stats := mustLoadCSV(t, sqlite, "codes", descriptor)
+	assert.Equal(t, 3, stats.Rows)
+	assert.Equal(t, ColumnTypeInteger, descriptor.Columns[0].Type)
+}
+
+func TestLoadCSV_TypeDefaults(t *testing.T) {
+	content := "name,qty,price,sold_at\nfirst,,,\nsecond,2,2.5,2020-05-01\n"
+	tests := []struct {
+		name         string
+		typeDefaults map[ColumnType]string
+		expected     []interface{}
+	}{
+		{"real_null_text_empty", map[ColumnType]string{ColumnTypeReal: TypeDefaultNull, ColumnTypeInteger: TypeDefaultNull}, []interface{}{nil, nil}},
+		{"zero", map[ColumnType]string{ColumnTypeReal: TypeDefaultZero, ColumnTypeInteger: TypeDefaultZero}, []interface{}{int64(0), float64(0)}},
+		{"literal", map[ColumnType]string{ColumnTypeReal: "-1.5", ColumnTypeInteger: "-1"}, []interface{}{int64(-1), -1.5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlite := newTestDB(t)
+			descriptor := newTestDescriptor(writeTestCSV(t, content))
+			descriptor.Columns = []Column{
+				{Type: ColumnTypeText, Name: "name"},
+				{Type: ColumnTypeInteger, Name: "qty"},
+				{Type: ColumnTypeReal, Name: "price"},
+				{Type: ColumnTypeDate, Name: "sold_at"},
+			}
+			descriptor.TypeDefaults = test.typeDefaults
+			mustLoadCSV(t, sqlite, "sales", descriptor)
+
+			var qty, price interface{}
+			require.NoError(t, sqlite.db.QueryRow("SELECT qty, price FROM sales WHERE name = 'first'").Scan(&qty, &price))
+			assert.Equal(t, test.expected, []interface{}{qty, price})
+		})
+	}
+}
+
+func TestCreateTableFor_TypeDefaults(t *testing.T) {
+	columns := []Column{{Type: ColumnTypeText, Name: "name"}, {Type: ColumnTypeReal, Name: "price"}}
+
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "t"("name" text DEFAULT "","price" real )`, createTableFor("t", columns, nil))
+	assert.Equal(
+		t,
+		`CREATE TABLE IF NOT EXISTS "t"("name" text DEFAULT 'n/a',"price" real )`,
+		createTableFor("t", columns, map[ColumnType]string{ColumnTypeText: "n/a", ColumnTypeReal: TypeDefaultNull}),
+	)
+}
+
+func TestLoadCSV_TrimCutset(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "code,qty\n**A**,***42\n*B,7**\n"))
+	descriptor.Columns = []Column{
+		{Name: "code"},
+		{Name: "qty", TrimCutset: "*"},
+	}
+	mustLoadCSV(t, sqlite, "stock", descriptor)
+
+	assert.Equal(t, ColumnTypeInteger, descriptor.Columns[1].Type)
+
+	var code string
+	var qty int64
+	require.NoError(t, sqlite.db.QueryRow("SELECT code, qty FROM stock WHERE rowid = 1").Scan(&code, &qty))
+	// Other columns are kept as is
+	assert.Equal(t, "**A**", code)
+	assert.Equal(t, int64(42), qty)
+	require.NoError(t, sqlite.db.QueryRow("SELECT qty FROM stock WHERE rowid = 2").Scan(&qty))
+	assert.Equal(t, int64(7), qty)
+}
+
+func TestLoadCSV_SameTableTwice(t *testing.T) {
+	sqlite := newTestDB(t)
+	filename := writeTestCSV(t, "name,qty\nfirst,1\n")
+	mustLoadCSV(t, sqlite, "sales", newTestDescriptor(filename))
+
+	require.NoError(t, ioutil.WriteFile(filename, []byte("name,qty,price\nfirst,1,1.5\nsecond,2,2.5\n"), 0644))
+	modTime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filename, modTime, modTime))
+	stats := mustLoadCSV(t, sqlite, "sales", newTestDescriptor(filename))
+
+	assert.True(t, stats.Loaded)
+	assert.Equal(t, 2, countRows(t, sqlite, "sales"))
+	var price float64
+	require.NoError(t, sqlite.db.QueryRow("SELECT price FROM sales WHERE name = 'second'").Scan(&price))
+	assert.Equal(t, 2.5, price)
+}
+
+func TestLoadCSV_SharedInMemoryDB(t *testing.T) {
+	first := newTestDB(t)
+	mustLoadCSV(t, first, "sales", newTestDescriptor(writeTestCSV(t, "name\nfirst\n")))
+
+	// A second DB over the same shared cache sees the tables of the first one
+	second := &DbSqlite{db: first.db, logger: nopLogger{}}
+	require.NoError(t, second.Init())
+	_, err := first.db.Exec(fmt.Sprintf("DELETE FROM %s", metaCsvTable))
+	require.NoError(t, err)
+
+	stats := mustLoadCSV(t, second, "sales", newTestDescriptor(writeTestCSV(t, "name\nfirst\nsecond\n")))
+	assert.True(t, stats.Loaded)
+	assert.Equal(t, 2, countRows(t, second, "sales"))
+}
+
+func TestLoadCSV_MaxColumns(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "a,b,c\n1,2,3\n"))
+	descriptor.MaxColumns = 2
+	_, err := sqlite.LoadCSV("wide", descriptor)
+	assert.EqualError(t, err, "CSV has 3 columns, the limit is 2 (is the delimiter right?)")
+
+	// SQLite limit by default
+	header := make([]string, defaultMaxColumns+1)
+	for i := range header {
+		header[i] = fmt.Sprintf("c%d", i)
+	}
+	descriptor = newTestDescriptor(writeTestCSV(t, strings.Join(header, ",")+"\n"))
+	_, err = sqlite.LoadCSV("wider", descriptor)
+	assert.EqualError(t, err, "CSV has 2001 columns, the limit is 2000 (is the delimiter right?)")
+}
+
+func TestLoadCSV_AllowShortRows(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "id,note,tag\n1,,a\n2,b\n3\n"))
+	_, err := sqlite.LoadCSV("strict_rows", descriptor)
+	assert.Error(t, err)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "id,note,tag\n1,,a\n2,b\n3\n"))
+	descriptor.AllowShortRows = true
+	stats := mustLoadCSV(t, sqlite, "short_rows", descriptor)
+	assert.Equal(t, 3, stats.Rows)
+
+	rows, err := sqlite.db.Query("SELECT note, tag FROM short_rows ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	values := make([][]interface{}, 0)
+	for rows.Next() {
+		var note, tag sql.NullString
+		require.NoError(t, rows.Scan(&note, &tag))
+		values = append(values, []interface{}{note, tag})
+	}
+	assert.Equal(t, [][]interface{}{
+		// Present but empty
+		{sql.NullString{String: "", Valid: true}, sql.NullString{String: "a", Valid: true}},
+		// Omitted
+		{sql.NullString{String: "b", Valid: true}, sql.NullString{}},
+		{sql.NullString{}, sql.NullString{}},
+	}, values)
+
+	// Extra fields would be lost
+	descriptor = newTestDescriptor(writeTestCSV(t, "a,b\n1,2,3\n4\n"))
+	descriptor.AllowShortRows = true
+	_, err = sqlite.LoadCSV("long_first_row", descriptor)
+	assert.EqualError(t, err, "row 1: the row has 3 fields, but the header has 2")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "a,b\n1,2\n4\n5,6,7\n"))
+	descriptor.AllowShortRows = true
+	_, err = sqlite.LoadCSV("long_row", descriptor)
+	assert.EqualError(t, err, "row 3: the row has 3 fields, but the header has 2")
+}
+
+func TestStrToValue_TruncateTo(t *testing.T) {
+	descriptor := &FileDescriptor{}
+	date := &Column{Type: ColumnTypeDate, Name: "at", TruncateTo: time.Minute}
+	value, err := strToValue("2020-05-01 15:04:37", date, descriptor)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 5, 1, 15, 4, 0, 0, time.UTC), value)
+
+	date.TruncateTo = time.Hour
+	value, err = strToValue("2020-05-01 15:04:37", date, descriptor)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 5, 1, 15, 0, 0, 0, time.UTC), value)
+
+	timestamp := &Column{Type: ColumnTypeTimestamp, Name: "ts", TruncateTo: time.Minute}
+	value, err = strToValue("1588345477", timestamp, descriptor)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1588345440), value)
+
+	value, err = strToValue("-61", timestamp, descriptor)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-120), value)
+
+	// No-op when unset
+	timestamp.TruncateTo = 0
+	value, err = strToValue("1588345477", timestamp, descriptor)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1588345477), value)
+}
+
+func TestLoadCSV_EmptyHeader(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, " , ,\nname,qty,price\nfirst,1,1.5\n"))
+	_, err := sqlite.LoadCSV("sales", descriptor)
+	assert.EqualError(t, err, "the header line has only empty cells, is there a leading blank line?")
+
+	exists, err := sqlite.ifTableExists("sales")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestStrToValue_IntBase(t *testing.T) {
+	descriptor := &FileDescriptor{}
+	tests := []struct {
+		base     int
+		value    string
+		expected interface{}
+	}{
+		{16, "0x1F", int64(31)},
+		{16, "FF", int64(255)},
+		{16, "-0xff", int64(-255)},
+		{8, "0o17", int64(15)},
+		{2, "0b101", int64(5)},
+		{0, "0x1F", int64(31)},
+		{0, "010", int64(10)},
+		{10, "42", int64(42)},
+		// Not valid in the base, kept as is
+		{8, "19", "19"},
+	}
+	for _, test := range tests {
+		value, err := strToValue(test.value, &Column{Type: ColumnTypeInteger, Name: "n", IntBase: test.base}, descriptor)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, value, "%s (base %d)", test.value, test.base)
+	}
+
+	_, err := strToValue("0xZZ", &Column{Type: ColumnTypeInteger, Name: "n", IntBase: 16}, &FileDescriptor{Strict: true})
+	assert.Error(t, err)
+}
+
+func TestLoadCSV_IntBaseDetection(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "code,flags\n0x1F,0x1F\n0xFF,0xFF\n"))
+	descriptor.Columns = []Column{{Name: "code", IntBase: 16}, {Name: "flags"}}
+	mustLoadCSV(t, sqlite, "codes", descriptor)
+
+	assert.Equal(t, ColumnTypeInteger, descriptor.Columns[0].Type)
+	assert.Equal(t, ColumnTypeText, descriptor.Columns[1].Type)
+	var sum int64
+	require.NoError(t, sqlite.db.QueryRow("SELECT SUM(code) FROM codes").Scan(&sum))
+	assert.Equal(t, int64(31+255), sum)
+}
+
+func TestCompareHeader(t *testing.T) {
+	expected := []string{"name", "qty", "price"}
+	tests := []struct {
+		name        string
+		header      []string
+		ignoreOrder bool
+		err         string
+	}{
+		{"same", []string{"name", "qty", "price"}, false, ""},
+		{"added", []string{"name", "qty", "price", "discount"}, false, "header does not match the expected one: unexpected [discount]"},
+		{"removed", []string{"name", "price"}, false, "header does not match the expected one: missing [qty]"},
+		{"renamed", []string{"name", "quantity", "price"}, true, "header does not match the expected one: missing [qty]; unexpected [quantity]"},
+		{"reordered", []string{"qty", "name", "price"}, false, "header does not match the expected one: expected order [name, qty, price], got [qty, name, price]"},
+		{"reordered_tolerated", []string{"qty", "name", "price"}, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := compareHeader(expected, test.header, test.ignoreOrder)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestLoadCSV_ExpectedHeader(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,price\nfirst,1.5\n"))
+	descriptor.ExpectedHeader = []string{"name", "qty", "price"}
+	_, err := sqlite.LoadCSV("sales", descriptor)
+	assert.EqualError(t, err, "header does not match the expected one: missing [qty]")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "price,name\n1.5,first\n"))
+	descriptor.ExpectedHeader = []string{"name", "price"}
+	descriptor.IgnoreHeaderOrder = true
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+}
+
+// Bare numbers like 20200501 or 1588345477 are also valid dates for dateparse, they must stay numbers
+func TestLoadCSV_NumbersAreNeverDates(t *testing.T) {
+	for _, value := range []string{"20200501", "1588345477", "1588345477000"} {
+		_, err := dateparse.ParseAny(value)
+		require.NoError(t, err, "%s is expected to be ambiguous", value)
+		assert.Equal(t, ColumnTypeInteger, detectDatatype(value))
+
+		converted, err := strToValue(value, &Column{Type: ColumnTypeInteger, Name: "id"}, &FileDescriptor{Strict: true})
+		require.NoError(t, err)
+		assert.IsType(t, int64(0), converted)
+	}
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "id,name\n20200501,first\n20200502,second\n"))
+	mustLoadCSV(t, sqlite, "orders", descriptor)
+	assert.Equal(t, ColumnTypeInteger, descriptor.Columns[0].Type)
+
+	var id interface{}
+	require.NoError(t, sqlite.db.QueryRow("SELECT id FROM orders WHERE name = 'second'").Scan(&id))
+	assert.Equal(t, int64(20200502), id)
+}
+
+func TestStrToValue_NullifyBlank(t *testing.T) {
+	text := &Column{Type: ColumnTypeText, Name: "name"}
+	price := &Column{Type: ColumnTypeReal, Name: "price"}
+	date := &Column{Type: ColumnTypeDate, Name: "sold_at", DefaultDate: "2020-01-01"}
+	tests := []struct {
+		name       string
+		descriptor *FileDescriptor
+		column     *Column
+		value      string
+		expected   interface{}
+	}{
+		{"real_blank", &FileDescriptor{NullifyBlank: true}, price, "  ", nil},
+		{"real_blank_off", &FileDescriptor{}, price, "  ", "  "},
+		{"date_blank_over_default", &FileDescriptor{NullifyBlank: true}, date, " ", nil},
+		{"text_whitespace_kept", &FileDescriptor{NullifyBlank: true}, text, "  ", "  "},
+		{"text_whitespace_trimmed", &FileDescriptor{NullifyBlank: true, TrimSpace: true}, text, "  ", nil},
+		{"text_empty", &FileDescriptor{NullifyBlank: true}, text, "", nil},
+		{"text_trimmed_only", &FileDescriptor{TrimSpace: true}, text, " a ", "a"},
+		{"null_value", &FileDescriptor{NullValues: []string{"N/A"}}, price, "N/A", nil},
+		{"null_value_after_trim", &FileDescriptor{NullValues: []string{"N/A"}, TrimSpace: true}, text, " N/A ", nil},
+		{"null_value_exact", &FileDescriptor{NullValues: []string{"N/A"}}, text, "n/a", "n/a"},
+		{"value", &FileDescriptor{NullifyBlank: true, TrimSpace: true}, price, " 1.5 ", 1.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := strToValue(test.value, test.column, test.descriptor)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, value)
+		})
+	}
+}
+
+func TestParseDate_CachedLayout(t *testing.T) {
+	column := &Column{Type: ColumnTypeDate, Name: "at"}
+	for _, value := range []string{"2020-05-01 10:00:00", "2020-05-02 11:30:00", "May 3, 2020", "2020-05-04 12:00:00", "1588345477"} {
+		expected, err := dateparse.ParseAny(value)
+		require.NoError(t, err)
+		actual, err := parseDate(value, column)
+		require.NoError(t, err)
+		assert.True(t, expected.Equal(actual), "%s: expected %s, got %s", value, expected, actual)
+	}
+	// Unix timestamps have no layout
+	assert.Equal(t, "", column.dateLayout)
+
+	_, err := parseDate("2020-05-01 10:00:00", column)
+	require.NoError(t, err)
+	assert.Equal(t, "2006-01-02 15:04:05", column.dateLayout)
+}
+
+func TestLoadCSV_DateLayoutOfPreviousLoad(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "at\n2020-05-01\n"))
+	// Left by a load of a file with days before months, it would read May 1 as January 5
+	descriptor.Columns = []Column{{Name: "at", Type: ColumnTypeDate, dateLayout: "2006-02-01"}}
+	mustLoadCSV(t, sqlite, "events", descriptor)
+
+	var at time.Time
+	require.NoError(t, sqlite.db.QueryRow("SELECT at FROM events").Scan(&at))
+	assert.Equal(t, time.May, at.Month())
+	assert.Equal(t, "2006-01-02", descriptor.Columns[0].dateLayout)
+}
+
+// Compares guessing the format of every value with reusing the recognized layout
+func BenchmarkParseDate(b *testing.B) {
+	values := make([]string, 1000)
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := range values {
+		values[i] = start.Add(time.Duration(i) * time.Minute).Format("2006-01-02 15:04:05")
+	}
+
+	b.Run("parse_any", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := dateparse.ParseAny(values[i%len(values)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached_layout", func(b *testing.B) {
+		column := &Column{Type: ColumnTypeDate, Name: "at"}
+		for i := 0; i < b.N; i++ {
+			if _, err := parseDate(values[i%len(values)], column); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestLoadCSV_AllowedValues(t *testing.T) {
+	content := "name,status\nfirst,active\nsecond,activ\nthird,ACTIVE\n"
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "status", Type: ColumnTypeText, AllowedValues: []string{"active", "inactive"}, AllowedValuesIgnoreCase: true},
+	}
+	stats := mustLoadCSV(t, sqlite, "users", descriptor)
+
+	assert.Equal(t, 3, stats.Rows)
+	assert.Equal(t, []string{"value `activ` of column `status` is not one of the allowed values"}, stats.Warnings)
+	var status string
+	require.NoError(t, sqlite.db.QueryRow("SELECT status FROM users WHERE name = 'second'").Scan(&status))
+	assert.Equal(t, "activ", status)
+
+	// Case sensitive and strict
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Strict = true
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "status", Type: ColumnTypeText, AllowedValues: []string{"active", "activ"}},
+	}
+	_, err := sqlite.LoadCSV("strict_users", descriptor)
+	assert.EqualError(t, err, "row 3: value `ACTIVE` of column `status` is not one of the allowed values")
+}
+
+func TestLoadCSV_AddLoadTimestamp(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty\nfirst,1\nsecond,2\nthird,3\n"))
+	descriptor.AddLoadTimestamp = true
+	started := time.Now().Add(-time.Second)
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	// The column is not a CSV one
+	assert.Equal(t, []string{"name", "qty"}, getColumnNames(descriptor.Columns))
+	var count int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(DISTINCT _loaded_at) FROM sales").Scan(&count))
+	assert.Equal(t, 1, count)
+	var loadedAt time.Time
+	require.NoError(t, sqlite.db.QueryRow("SELECT _loaded_at FROM sales LIMIT 1").Scan(&loadedAt))
+	assert.True(t, loadedAt.After(started))
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,qty\nfirst,1\n"))
+	descriptor.AddLoadTimestamp = true
+	descriptor.LoadTimestampColumn = "qty"
+	_, err := sqlite.LoadCSV("clash", descriptor)
+	assert.EqualError(t, err, "load timestamp column `qty` clashes with a CSV column")
+}
+
+func TestLoadCSV_ExpectedRows(t *testing.T) {
+	sqlite := newTestDB(t)
+	expected := 3
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty\nfirst,1\nsecond,2\n"))
+	descriptor.ExpectedRows = &expected
+	_, err := sqlite.LoadCSV("sales", descriptor)
+	assert.EqualError(t, err, "row count mismatch: expected 3, loaded 2")
+	exists, err := sqlite.ifTableExists("sales")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	expected = 2
+	descriptor = newTestDescriptor(descriptor.Filename)
+	descriptor.ExpectedRows = &expected
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+}
+
+func TestLoadCSV_Boolean(t *testing.T) {
+	sqlite := newTestDB(t)
+
+	// 0/1 alone is kept as integer
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,active\nfirst,1\nsecond,0\n"))
+	descriptor.StrictSchema = true
+	mustLoadCSV(t, sqlite, "flags", descriptor)
+	assert.Equal(t, ColumnTypeInteger, descriptor.Columns[1].Type)
+
+	// 0/1 mixed with word booleans is boolean
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,active\nfirst,1\nsecond,no\nthird,Yes\n"))
+	descriptor.StrictSchema = true
+	mustLoadCSV(t, sqlite, "flags", descriptor)
+	assert.Equal(t, ColumnTypeBoolean, descriptor.Columns[1].Type)
+
+	var active int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM flags WHERE active").Scan(&active))
+	assert.Equal(t, 2, active)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,active\nfirst,2\nsecond,no\n"))
+	descriptor.StrictSchema = true
+	_, err := sqlite.LoadCSV("flags", descriptor)
+	assert.EqualError(t, err, "ambiguous type of column `active`: `2` is integer, but `no` is text")
+
+	// Word booleans are detected without StrictSchema as well
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,active\nfirst,true\nsecond,0\nthird,no\n"))
+	mustLoadCSV(t, sqlite, "flags_default", descriptor)
+	assert.Equal(t, ColumnTypeBoolean, descriptor.Columns[1].Type)
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM flags_default WHERE active").Scan(&active))
+	assert.Equal(t, 1, active)
+
+	// A single word is not enough to take the column for flags
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,country\nfirst,NO\nsecond,SE\n"))
+	mustLoadCSV(t, sqlite, "countries", descriptor)
+	assert.Equal(t, ColumnTypeText, descriptor.Columns[1].Type)
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,country\nfirst,NO\nsecond,SE\n"))
+	descriptor.StrictSchema = true
+	mustLoadCSV(t, sqlite, "countries_strict", descriptor)
+	assert.Equal(t, ColumnTypeText, descriptor.Columns[1].Type)
+
+	// A declared boolean column reads 0/1 as flags
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,active\nfirst,1\nsecond,0\n"))
+	descriptor.Columns = []Column{{Name: "name"}, {Name: "active", Type: ColumnTypeBoolean}}
+	mustLoadCSV(t, sqlite, "flags", descriptor)
+
+	var value bool
+	require.NoError(t, sqlite.db.QueryRow("SELECT active FROM flags WHERE name = 'first'").Scan(&value))
+	assert.True(t, value)
+}
+
+func TestLoadCSV_ReplaceOnReload(t *testing.T) {
+	sqlite := newTestDB(t)
+	filename := writeTestCSV(t, "name,qty\nfirst,1\nsecond,2\n")
+	mustLoadCSV(t, sqlite, "sales", newTestDescriptor(filename))
+
+	done := make(chan struct{})
+	failures := make(chan error, 1)
+	go func() {
+		defer close(failures)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			result, err := sqlite.Query("SELECT COUNT(*) FROM sales")
+			if err == nil {
+				_, err = result.Next()
+				result.Release()
+			}
+			if err != nil {
+				failures <- err
+				return
+			}
+		}
+	}()
+
+	for i := 3; i < 13; i++ {
+		require.NoError(t, ioutil.WriteFile(filename, []byte(fmt.Sprintf("name,qty\nfirst,1\nsecond,%d\n", i)), 0644))
+		require.NoError(t, os.Chtimes(filename, time.Now(), time.Unix(int64(i), 0)))
+		descriptor := newTestDescriptor(filename)
+		descriptor.ReplaceOnReload = true
+		stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+		assert.True(t, stats.Loaded)
+	}
+	close(done)
+	assert.NoError(t, <-failures)
+
+	var qty int
+	require.NoError(t, sqlite.db.QueryRow("SELECT qty FROM sales WHERE name = 'second'").Scan(&qty))
+	assert.Equal(t, 12, qty)
+	tables, err := sqlite.tables()
+	require.NoError(t, err)
+	assert.NotContains(t, tables, reloadTable("sales"))
+}
+
+func TestLoadCSV_NulBytes(t *testing.T) {
+	content := "name,qty\nfir\x00st,1\nsecond,2\x00\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+	assert.Equal(t, []string{
+		"NUL bytes are stripped from the value of column `name`",
+		"NUL bytes are stripped from the value of column `qty`",
+	}, stats.Warnings)
+
+	var total int
+	require.NoError(t, sqlite.db.QueryRow("SELECT SUM(qty) FROM sales WHERE name IN ('first', 'second')").Scan(&total))
+	assert.Equal(t, 3, total)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.NulBytes = NulBytesReject
+	_, err := sqlite.LoadCSV("rejected", descriptor)
+	assert.EqualError(t, err, "row 1: value of column `name` contains a NUL byte")
+}
+
+func TestLoadCSV_Analyze(t *testing.T) {
+	sqlite := newTestDB(t)
+	content := &strings.Builder{}
+	content.WriteString("id,region\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(content, "%d,r%d\n", i, i%50)
+	}
+	filename := writeTestCSV(t, content.String())
+	statRows := func(table string) int {
+		var count int
+		require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM sqlite_stat1 WHERE tbl = ?", table).Scan(&count))
+		return count
+	}
+
+	// On by default once an index is created
+	descriptor := newTestDescriptor(filename)
+	descriptor.PostLoadSQL = []string{"CREATE INDEX IF NOT EXISTS orders_region ON orders(region)"}
+	mustLoadCSV(t, sqlite, "orders", descriptor)
+	assert.Equal(t, 1, statRows("orders"))
+
+	var id, parent, notUsed int
+	var detail string
+	require.NoError(t, sqlite.db.QueryRow("EXPLAIN QUERY PLAN SELECT * FROM orders WHERE region = 'r7'").Scan(&id, &parent, &notUsed, &detail))
+	assert.Contains(t, detail, "USING INDEX orders_region")
+
+	analyze := false
+	descriptor = newTestDescriptor(filename)
+	descriptor.PostLoadSQL = []string{"CREATE INDEX IF NOT EXISTS returns_region ON returns(region)"}
+	descriptor.Analyze = &analyze
+	mustLoadCSV(t, sqlite, "returns", descriptor)
+	assert.Equal(t, 0, statRows("returns"))
+
+	// Without an index only on demand
+	mustLoadCSV(t, sqlite, "plain", newTestDescriptor(filename))
+	assert.Equal(t, 0, statRows("plain"))
+	analyze = true
+	descriptor = newTestDescriptor(filename)
+	descriptor.Analyze = &analyze
+	mustLoadCSV(t, sqlite, "analyzed", descriptor)
+	assert.Equal(t, 1, statRows("analyzed"))
+}
+
+func TestLoadCSV_PostLoadSQL(t *testing.T) {
+	sqlite := newTestDB(t)
+	filename := writeTestCSV(t, "name,qty,price\nfirst,2,1.5\nsecond,3,2\n")
+	descriptor := newTestDescriptor(filename)
+	descriptor.PostLoadSQL = []string{
+		"CREATE INDEX IF NOT EXISTS sales_name ON sales(name)",
+		"CREATE VIEW IF NOT EXISTS sales_total AS SELECT SUM(qty * price) AS total FROM sales",
+	}
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	var total float64
+	require.NoError(t, sqlite.db.QueryRow("SELECT total FROM sales_total").Scan(&total))
+	assert.Equal(t, 9.0, total)
+
+	// The reloaded table is swapped in before the statements run
+	require.NoError(t, ioutil.WriteFile(filename, []byte("name,qty,price\nfirst,4,1.5\n"), 0644))
+	require.NoError(t, os.Chtimes(filename, time.Now(), time.Unix(100, 0)))
+	descriptor = newTestDescriptor(filename)
+	descriptor.ReplaceOnReload = true
+	descriptor.PostLoadSQL = []string{"CREATE INDEX IF NOT EXISTS sales_name ON sales(name)"}
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+	require.NoError(t, sqlite.db.QueryRow("SELECT total FROM sales_total").Scan(&total))
+	assert.Equal(t, 6.0, total)
+	var indexes int
+	require.NoError(t, sqlite.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'sales'").Scan(&indexes))
+	assert.Equal(t, 1, indexes)
+
+	// A failed statement rolls back the load
+	descriptor = newTestDescriptor(writeTestCSV(t, "name\nfirst\n"))
+	descriptor.PostLoadSQL = []string{"INSERT INTO archive SELECT * FROM orders"}
+	_, err := sqlite.LoadCSV("orders", descriptor)
+	assert.EqualError(t, err, "post-load SQL statement 1: no such table: archive")
+	exists, err := sqlite.ifTableExists("orders")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "name\nfirst\n"))
+	descriptor.PostLoadSQL = []string{"SELECT 1", " "}
+	_, err = sqlite.LoadCSV("orders", descriptor)
+	assert.EqualError(t, err, "post-load SQL statement 2 is empty")
+}
+
+func TestLoadCSV_GeneratedColumn(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "a,b\n1,2\n3,4\n"))
+	descriptor.StrictSchema = true
+	descriptor.Columns = []Column{
+		{Name: "a"},
+		{Name: "b"},
+		{Name: "total", Type: ColumnTypeInteger, Expression: "a + b"},
+	}
+	stats := mustLoadCSV(t, sqlite, "sums", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+
+	var total int
+	require.NoError(t, sqlite.db.QueryRow("SELECT SUM(total) FROM sums").Scan(&total))
+	assert.Equal(t, 10, total)
+
+	columns, err := sqlite.schema("sums")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{
+		{Name: "a", Type: ColumnTypeInteger},
+		{Name: "b", Type: ColumnTypeInteger},
+		{Name: "total", Type: ColumnTypeInteger},
+	}, columns)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "a,b\n1,2\n"))
+	descriptor.Columns = []Column{{Name: "a"}, {Name: "b"}, {Name: "total", Expression: "a + c"}}
+	_, err = sqlite.LoadCSV("broken", descriptor)
+	assert.EqualError(t, err, "generated column `total`: no such column: c")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "a,b\n1,2\n"))
+	descriptor.Columns = []Column{{Name: "a"}, {Name: "b", Expression: "a * 2"}}
+	_, err = sqlite.LoadCSV("broken", descriptor)
+	assert.EqualError(t, err, "generated column `b` clashes with a CSV column")
+}
+
+func TestLoadCSV_ColumnNullValues(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,delta,code\nfirst,-1,N/A\nsecond,5,-1\nN/A,-1,A7\n"))
+	descriptor.NullValues = []string{"N/A"}
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "delta", Type: ColumnTypeInteger, NullValues: []string{"-1"}},
+		{Name: "code", Type: ColumnTypeText},
+	}
+	mustLoadCSV(t, sqlite, "changes", descriptor)
+
+	// -1 is NULL only in delta, N/A is still NULL in the other columns
+	var nullDeltas, nullCodes, nullNames int
+	require.NoError(t, sqlite.db.QueryRow(
+		"SELECT SUM(delta IS NULL), SUM(code IS NULL), SUM(name IS NULL) FROM changes",
+	).Scan(&nullDeltas, &nullCodes, &nullNames))
+	assert.Equal(t, 2, nullDeltas)
+	assert.Equal(t, 1, nullCodes)
+	assert.Equal(t, 1, nullNames)
+
+	var code string
+	require.NoError(t, sqlite.db.QueryRow("SELECT code FROM changes WHERE name = 'second'").Scan(&code))
+	assert.Equal(t, "-1", code)
+}
+
+// Cancels the context once the given count of bytes is read
+type cancellingReader struct {
+	reader io.Reader
+	after  int
+	cancel context.CancelFunc
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.after -= n
+	if r.after <= 0 {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestLoadCSVContext_Cancel(t *testing.T) {
+	var content strings.Builder
+	content.WriteString("name,qty\n")
+	for i := 0; i < 100000; i++ {
+		content.WriteString(fmt.Sprintf("row%d,%d\n", i, i))
+	}
+
+	sqlite := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	descriptor := newTestDescriptor("sales.csv")
+	descriptor.Source = &readerSource{reader: &cancellingReader{
+		reader: strings.NewReader(content.String()),
+		after:  content.Len() / 2,
+		cancel: cancel,
+	}}
+	_, err := sqlite.LoadCSVContext(ctx, "sales", descriptor)
+	assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+	exists, err := sqlite.ifTableExists("sales")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = sqlite.LoadCSVContext(ctx, "sales", newTestDescriptor(writeTestCSV(t, "name\nfirst\n")))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupted := sqlite3.Error{Code: sqlite3.ErrInterrupt}
+	assert.Equal(t, interrupted, contextError(ctx, interrupted))
+	cancel()
+	assert.Equal(t, context.Canceled, contextError(ctx, interrupted))
+	other := errors.New("disk I/O error")
+	assert.Equal(t, other, contextError(ctx, other))
+}
+
+func TestLoadCSV_ColumnIndex(t *testing.T) {
+	content := "2020-05-01,north,10\n2020-05-02,south,12\n"
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.NoHeader = true
+	descriptor.Columns = []Column{
+		{Name: "qty", Index: 3},
+		{Name: "region", Index: 2},
+	}
+	stats := mustLoadCSV(t, sqlite, "sales", descriptor)
+	assert.Equal(t, 2, stats.Rows)
+
+	columns, err := sqlite.schema("sales")
+	require.NoError(t, err)
+	assert.Equal(t, []Column{{Name: "qty", Type: ColumnTypeInteger}, {Name: "region", Type: ColumnTypeText}}, columns)
+	var qty int
+	require.NoError(t, sqlite.db.QueryRow("SELECT qty FROM sales WHERE region = 'south'").Scan(&qty))
+	assert.Equal(t, 12, qty)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.NoHeader = true
+	descriptor.Columns = []Column{{Name: "qty", Index: 4}}
+	_, err = sqlite.LoadCSV("broken", descriptor)
+	assert.EqualError(t, err, "index 4 of column `qty` is out of range, the file has 3 columns")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.NoHeader = true
+	descriptor.Columns = []Column{{Name: "qty", Index: 3}, {Name: "total", Index: 3}}
+	_, err = sqlite.LoadCSV("broken", descriptor)
+	assert.EqualError(t, err, "columns `qty` and `total` have the same index 3")
+}
+
+func TestApplyColumnIndexes(t *testing.T) {
+	// The indexed column wins over the header cell of the same name
+	header, err := applyColumnIndexes([]string{"a", "b", "c"}, []Column{{Name: "a", Index: 3}, {Name: "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "b", "a"}, header)
+}
+
+func TestLoadCSV_Range(t *testing.T) {
+	content := "name,age,score\nfirst,42,0.5\nsecond,131,0.9\nthird,-1,1.5\n"
+	minAge, maxAge, maxScore := 0.0, 130.0, 1.0
+	columns := func() []Column {
+		return []Column{
+			{Name: "name", Type: ColumnTypeText},
+			{Name: "age", Type: ColumnTypeInteger, Min: &minAge, Max: &maxAge},
+			{Name: "score", Type: ColumnTypeReal, Max: &maxScore},
+		}
+	}
+
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Columns = columns()
+	stats := mustLoadCSV(t, sqlite, "people", descriptor)
+	assert.Equal(t, 3, stats.Rows)
+	assert.Equal(t, []string{
+		"value `131` of column `age` is greater than the maximum 130",
+		"value `-1` of column `age` is less than the minimum 0",
+		"value `1.5` of column `score` is greater than the maximum 1",
+	}, stats.Warnings)
+	var age int
+	require.NoError(t, sqlite.db.QueryRow("SELECT age FROM people WHERE name = 'second'").Scan(&age))
+	assert.Equal(t, 131, age)
+
+	descriptor = newTestDescriptor(writeTestCSV(t, content))
+	descriptor.Columns = columns()
+	descriptor.Strict = true
+	_, err := sqlite.LoadCSV("strict_people", descriptor)
+	assert.EqualError(t, err, "row 2: value `131` of column `age` is greater than the maximum 130")
+
+	descriptor = newTestDescriptor(writeTestCSV(t, "name,age,score\nfirst,42,0.5\n"))
+	descriptor.Columns = columns()
+	descriptor.Strict = true
+	stats = mustLoadCSV(t, sqlite, "strict_people", descriptor)
+	assert.Empty(t, stats.Warnings)
+}
+
+func TestSnapshot(t *testing.T) {
+	sqlite := newTestDB(t)
+	mustLoadCSV(t, sqlite, "sales", newTestDescriptor(writeTestCSV(t, "name,qty\nfirst,1\nsecond,2\n")))
+
+	path := filepath.Join(t.TempDir(), "sales.db")
+	require.NoError(t, sqlite.Snapshot(path))
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	var total int
+	require.NoError(t, db.QueryRow("SELECT SUM(qty) FROM sales").Scan(&total))
+	assert.Equal(t, 3, total)
+
+	assert.EqualError(t, sqlite.Snapshot(path), fmt.Sprintf("snapshot file `%s` already exists", path))
+}
+
+func TestLoadCSV_EmptyAsZero(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "name,qty,price,discount\nfirst,,,\nsecond,2,2.5,0.5\n"))
+	descriptor.Columns = []Column{
+		{Name: "name", Type: ColumnTypeText},
+		{Name: "qty", Type: ColumnTypeInteger, EmptyAsZero: true},
+		{Name: "price", Type: ColumnTypeReal},
+		{Name: "discount", Type: ColumnTypeReal, EmptyAsZero: true},
+	}
+	mustLoadCSV(t, sqlite, "sales", descriptor)
+
+	var qty, price, discount interface{}
+	require.NoError(t, sqlite.db.QueryRow("SELECT qty, price, discount FROM sales WHERE name = 'first'").Scan(&qty, &price, &discount))
+	assert.Equal(t, []interface{}{int64(0), nil, float64(0)}, []interface{}{qty, price, discount})
+
+	// NULL does not drag the average down
+	var avgPrice, avgDiscount float64
+	require.NoError(t, sqlite.db.QueryRow("SELECT AVG(price), AVG(discount) FROM sales").Scan(&avgPrice, &avgDiscount))
+	assert.Equal(t, 2.5, avgPrice)
+	assert.Equal(t, 0.25, avgDiscount)
+}
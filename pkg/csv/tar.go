@@ -0,0 +1,79 @@
+package csv
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// LoadTar loads every .csv entry of a tar archive, gzip compressed or not, into its own table named after the entry
+// (see TableNameFor). Other entries are skipped. The entries are streamed, nothing is extracted to disk.
+// The descriptor is a template of the entries, its Filename and Source are set per entry.
+// Returns the tables in the order of the entries.
+func (sqlite *DbSqlite) LoadTar(r io.Reader, descriptor *FileDescriptor) ([]string, error) {
+	data := bufio.NewReader(r)
+	if magic, _ := data.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(data)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	} else {
+		r = data
+	}
+
+	archive := tar.NewReader(r)
+	tables := make([]string, 0)
+	entries := make(map[string]string)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || !strings.EqualFold(path.Ext(header.Name), ".csv") {
+			continue
+		}
+		tableName := TableNameFor(header.Name)
+		if other, ok := entries[tableName]; ok {
+			return nil, errors.New(fmt.Sprintf("tar entries `%s` and `%s` are loaded into the same table `%s`", other, header.Name, tableName))
+		}
+		entries[tableName] = header.Name
+
+		entryDescriptor := cloneDescriptor(descriptor)
+		entryDescriptor.Filename = header.Name
+		entryDescriptor.Source = &tarEntrySource{reader: archive, size: header.Size, modTime: header.ModTime.Unix()}
+		if _, err := sqlite.LoadCSV(tableName, entryDescriptor); err != nil {
+			return nil, errors.New(fmt.Sprintf("tar entry `%s`: %s", header.Name, err.Error()))
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, nil
+}
+
+// The current entry of a tar archive, its header tells whether it has been changed
+type tarEntrySource struct {
+	reader  io.Reader
+	size    int64
+	modTime int64
+}
+
+func (s *tarEntrySource) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(s.reader), nil
+}
+
+func (s *tarEntrySource) Stat() (int64, int64, error) {
+	return s.size, s.modTime, nil
+}
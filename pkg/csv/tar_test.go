@@ -0,0 +1,65 @@
+package csv
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestTar(t *testing.T, w io.Writer, entries map[string]string, names ...string) {
+	archive := tar.NewWriter(w)
+	for _, name := range names {
+		content := entries[name]
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Unix(100, 0), Typeflag: tar.TypeReg}
+		if content == "" {
+			header.Typeflag = tar.TypeDir
+		}
+		require.NoError(t, archive.WriteHeader(header))
+		_, err := archive.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+}
+
+func TestLoadTar(t *testing.T) {
+	entries := map[string]string{
+		"backup/":                   "",
+		"backup/sales.csv":          "name,qty\nfirst,1\nsecond,2\n",
+		"backup/README.txt":         "not a csv",
+		"backup/2024 customers.CSV": "id,name\n1,Acme\n",
+	}
+	names := []string{"backup/", "backup/sales.csv", "backup/README.txt", "backup/2024 customers.CSV"}
+
+	var plain, compressed bytes.Buffer
+	writeTestTar(t, &plain, entries, names...)
+	gzipWriter := gzip.NewWriter(&compressed)
+	writeTestTar(t, gzipWriter, entries, names...)
+	require.NoError(t, gzipWriter.Close())
+
+	for name, archive := range map[string]*bytes.Buffer{"plain": &plain, "gzip": &compressed} {
+		t.Run(name, func(t *testing.T) {
+			sqlite := newTestDB(t)
+			tables, err := sqlite.LoadTar(archive, newTestDescriptor(""))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"sales", "_2024_customers"}, tables)
+			assert.Equal(t, 2, countRows(t, sqlite, "sales"))
+			assert.Equal(t, 1, countRows(t, sqlite, "_2024_customers"))
+		})
+	}
+}
+
+func TestLoadTar_SameTable(t *testing.T) {
+	entries := map[string]string{"a/sales.csv": "name\nfirst\n", "b/sales.csv": "name\nsecond\n"}
+	var archive bytes.Buffer
+	writeTestTar(t, &archive, entries, "a/sales.csv", "b/sales.csv")
+
+	sqlite := newTestDB(t)
+	_, err := sqlite.LoadTar(&archive, newTestDescriptor(""))
+	assert.EqualError(t, err, "tar entries `a/sales.csv` and `b/sales.csv` are loaded into the same table `sales`")
+}
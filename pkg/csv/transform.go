@@ -0,0 +1,117 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Transform reads the CSV from in the way LoadCSV does (column types, trimming, NULL values, Unpivot, ...) and
+// writes the converted rows as CSV to out, formatted by the options like ExportCSV, without building a DB.
+// Rows are streamed, so memory does not depend on the size of the data. On error the output is incomplete.
+// Options which need the table (Pivot, Distinct, PostLoadSQL, ...) are not supported.
+// The stats have no Table, Rows is the count of written rows
+func Transform(in io.Reader, out io.Writer, descriptor *FileDescriptor, options ExportOptions) (*LoadStats, error) {
+	if err := validateTransform(descriptor); err != nil {
+		return nil, err
+	}
+	// The caller may reuse the descriptor, e.g. to load the data
+	descriptor = cloneDescriptor(descriptor)
+	descriptor.Source = &readerSource{reader: in}
+	descriptor.warnings = &warningCollector{}
+	resetDateLayouts(descriptor.Columns)
+	reader, err := newCsvReader(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+
+	header, firstRow, columnsMap, err := readColumns(reader, descriptor, getDefaultLogger())
+	if err != nil {
+		return nil, err
+	}
+	columns := descriptor.Columns
+	toRows := func(values []string) ([][]interface{}, error) {
+		rowValues, err := valuesToRow(values, descriptor, columnsMap)
+		if err != nil {
+			return nil, err
+		}
+		return [][]interface{}{rowValues}, nil
+	}
+	if descriptor.Unpivot != nil {
+		unpivot, err := newUnpivotTransform(descriptor, columnsMap)
+		if err != nil {
+			return nil, err
+		}
+		columns = unpivot.columns
+		toRows = unpivot.toRows
+	}
+
+	writer := newExportWriter(out, options)
+	if !options.NoHeader {
+		writer.header(getColumnNames(columns))
+	}
+	textColumns := make([]bool, len(columns))
+	for i, column := range columns {
+		textColumns[i] = column.Type == ColumnTypeText
+	}
+
+	written := 0
+	reader.unread(firstRow)
+	for rowNumber := 1; ; rowNumber++ {
+		row, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRowLength(row, header, descriptor); err != nil {
+			return nil, rowError(rowNumber, err)
+		}
+		rows, err := toRows(row)
+		if err != nil {
+			return nil, rowError(rowNumber, err)
+		}
+		for _, values := range rows {
+			writer.row(values, textColumns)
+			written++
+		}
+	}
+	if err := writer.w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := reader.verifyChecksum(descriptor.ExpectedChecksum); err != nil {
+		return nil, err
+	}
+	return &LoadStats{Rows: written, Warnings: descriptor.warnings.list(), RawPrefix: reader.rawBytes()}, nil
+}
+
+func validateTransform(descriptor *FileDescriptor) error {
+	if descriptor == nil {
+		return errors.New("file descriptor is missed")
+	}
+	unsupported := ""
+	switch {
+	case descriptor.Pivot != nil:
+		unsupported = "Pivot"
+	case descriptor.Distinct:
+		unsupported = "Distinct"
+	case len(descriptor.PostLoadSQL) > 0:
+		unsupported = "PostLoadSQL"
+	case descriptor.ExpectedRows != nil:
+		unsupported = "ExpectedRows"
+	case descriptor.AddLoadTimestamp:
+		unsupported = "AddLoadTimestamp"
+	}
+	for _, column := range descriptor.Columns {
+		if column.isGenerated() {
+			unsupported = "generated column `" + column.Name + "`"
+			break
+		}
+	}
+	if unsupported != "" {
+		return errors.New(fmt.Sprintf("%s is not supported by Transform", unsupported))
+	}
+	return nil
+}
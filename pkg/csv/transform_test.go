@@ -0,0 +1,45 @@
+package csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	in := strings.NewReader("name,price,sold\n\"Smith, J\",$1;234.50,02.01.2021\nLee,N/A,\nN/A,1,\n")
+	descriptor := &FileDescriptor{
+		Delimiter:  ',',
+		TrimSpace:  true,
+		NullValues: []string{"N/A"},
+		Columns: []Column{
+			{Name: "name"},
+			{Name: "price", Type: ColumnTypeReal, TrimCutset: "$", ThousandsSeparator: ";"},
+			{Name: "sold", Type: ColumnTypeDate, Formats: []string{"02.01.2006"}},
+		},
+	}
+	out := &bytes.Buffer{}
+	stats, err := Transform(in, out, descriptor, ExportOptions{TypedNulls: true, DateLayout: "2006-01-02"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Rows)
+	// A NULL text is a quoted empty field, other NULLs are empty fields
+	assert.Equal(t, "name,price,sold\n\"Smith, J\",1234.5,2021-01-02\nLee,,\n\"\",1,\n", out.String())
+	// The descriptor is not bound to the reader
+	assert.Nil(t, descriptor.Source)
+}
+
+func TestTransform_Unsupported(t *testing.T) {
+	descriptor := &FileDescriptor{Delimiter: ',', Distinct: true}
+	_, err := Transform(strings.NewReader("a\n1\n"), &bytes.Buffer{}, descriptor, ExportOptions{})
+	assert.EqualError(t, err, "Distinct is not supported by Transform")
+}
+
+func TestTransform_RowError(t *testing.T) {
+	descriptor := &FileDescriptor{Delimiter: ',', Strict: true, Columns: []Column{{Name: "qty", Type: ColumnTypeInteger}}}
+	out := &bytes.Buffer{}
+	_, err := Transform(strings.NewReader("qty\n1\n2.5\n"), out, descriptor, ExportOptions{})
+	assert.EqualError(t, err, "row 2: value `2.5` of column `qty` is not a valid integer")
+}
@@ -0,0 +1,139 @@
+package csv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Unpivot turns columns into rows: every value column of a CSV row becomes a separate table row
+// (id columns..., variable, value), where variable holds the name of the value column.
+// For example `day,mon,tue` with ID column `day` produces rows `(day, 'mon', value)` and `(day, 'tue', value)`.
+type Unpivot struct {
+	// Columns copied into every produced row
+	IDColumns []string
+	// Columns turned into rows, all CSV columns except IDColumns if empty (generated columns are not in the CSV)
+	ValueColumns []string
+	// Name of the column holding the value column name, "variable" if empty
+	VariableColumn string
+	// Name of the column holding the value, "value" if empty
+	ValueColumn string
+}
+
+type unpivotTransform struct {
+	descriptor   *FileDescriptor
+	columnsMap   map[string]int
+	idColumns    []*Column
+	valueColumns []*Column
+	// Columns of the produced table
+	columns []Column
+}
+
+func newUnpivotTransform(descriptor *FileDescriptor, columnsMap map[string]int) (*unpivotTransform, error) {
+	unpivot := descriptor.Unpivot
+	t := &unpivotTransform{
+		descriptor: descriptor,
+		columnsMap: columnsMap,
+	}
+
+	for _, name := range unpivot.IDColumns {
+		column, err := t.findColumn(name)
+		if err != nil {
+			return nil, err
+		}
+		t.idColumns = append(t.idColumns, column)
+	}
+
+	if len(unpivot.ValueColumns) == 0 {
+		for i := range descriptor.Columns {
+			if _, ok := columnsMap[descriptor.Columns[i].Name]; !ok {
+				continue
+			}
+			if indexOf(unpivot.IDColumns, descriptor.Columns[i].Name) == -1 {
+				t.valueColumns = append(t.valueColumns, &descriptor.Columns[i])
+			}
+		}
+	} else {
+		for _, name := range unpivot.ValueColumns {
+			column, err := t.findColumn(name)
+			if err != nil {
+				return nil, err
+			}
+			t.valueColumns = append(t.valueColumns, column)
+		}
+	}
+	if len(t.valueColumns) == 0 {
+		return nil, errors.New("unpivot: there are no value columns")
+	}
+
+	variableColumn := unpivot.VariableColumn
+	if variableColumn == "" {
+		variableColumn = "variable"
+	}
+	valueColumn := unpivot.ValueColumn
+	if valueColumn == "" {
+		valueColumn = "value"
+	}
+	for _, column := range t.idColumns {
+		t.columns = append(t.columns, *column)
+	}
+	t.columns = append(t.columns,
+		Column{Type: ColumnTypeText, Name: variableColumn},
+		Column{Type: unpivotValueType(t.valueColumns), Name: valueColumn},
+	)
+
+	return t, nil
+}
+
+func (t *unpivotTransform) findColumn(name string) (*Column, error) {
+	if _, ok := t.columnsMap[name]; !ok {
+		return nil, errors.New(fmt.Sprintf("unpivot: column `%s` is not found", name))
+	}
+	for i := range t.descriptor.Columns {
+		if t.descriptor.Columns[i].Name == name {
+			return &t.descriptor.Columns[i], nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("unpivot: column `%s` is not found", name))
+}
+
+func (t *unpivotTransform) toRows(values []string) ([][]interface{}, error) {
+	idValues := make([]interface{}, 0)
+	for _, column := range t.idColumns {
+		value, err := fieldToValue(values, t.columnsMap[column.Name], column, t.descriptor)
+		if err != nil {
+			return nil, err
+		}
+		idValues = append(idValues, value)
+	}
+
+	rows := make([][]interface{}, 0)
+	for _, column := range t.valueColumns {
+		value, err := fieldToValue(values, t.columnsMap[column.Name], column, t.descriptor)
+		if err != nil {
+			return nil, err
+		}
+		row := append(append([]interface{}{}, idValues...), column.Name, value)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Value columns of the same type keep it, a mix of integer and real becomes real, anything else text
+func unpivotValueType(columns []*Column) ColumnType {
+	valueType := columns[0].Type
+	for _, column := range columns[1:] {
+		if column.Type == valueType {
+			continue
+		}
+		if isNumericType(column.Type) && isNumericType(valueType) {
+			valueType = ColumnTypeReal
+			continue
+		}
+		return ColumnTypeText
+	}
+	return valueType
+}
+
+func isNumericType(columnType ColumnType) bool {
+	return columnType == ColumnTypeInteger || columnType == ColumnTypeReal
+}
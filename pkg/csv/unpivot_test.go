@@ -0,0 +1,71 @@
+package csv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCSV_Unpivot(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		columns  []Column
+		unpivot  *Unpivot
+		rows     int
+		query    string
+		expected float64
+	}{
+		{
+			name:     "value",
+			content:  "station,day1,day2,day3\nA,1,2,3.5\nB,4,5,6\n",
+			unpivot:  &Unpivot{IDColumns: []string{"station"}, VariableColumn: "day"},
+			rows:     6,
+			query:    "SELECT value FROM readings WHERE station = 'A' AND day = 'day3'",
+			expected: 3.5,
+		},
+		{
+			name:     "sum",
+			content:  "station,day1,day2,day3\nA,1,2,3.5\nB,4,5,6\n",
+			unpivot:  &Unpivot{IDColumns: []string{"station"}, VariableColumn: "day"},
+			rows:     6,
+			query:    "SELECT SUM(value) FROM readings WHERE station = 'B'",
+			expected: 15,
+		},
+		{
+			// Generated columns are not turned into rows
+			name:     "generated_column",
+			content:  "station,day1,day2\nA,1,2\n",
+			columns:  []Column{{Name: "station"}, {Name: "day1"}, {Name: "day2"}, {Name: "total", Expression: "day1 + day2"}},
+			unpivot:  &Unpivot{IDColumns: []string{"station"}},
+			rows:     2,
+			query:    "SELECT COUNT(*) FROM readings WHERE variable = 'total'",
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sqlite := newTestDB(t)
+			descriptor := newTestDescriptor(writeTestCSV(t, test.content))
+			descriptor.Columns = test.columns
+			descriptor.Unpivot = test.unpivot
+
+			stats := mustLoadCSV(t, sqlite, "readings", descriptor)
+			assert.Equal(t, test.rows, stats.Rows)
+			var value float64
+			require.NoError(t, sqlite.db.QueryRow(test.query).Scan(&value))
+			assert.Equal(t, test.expected, value)
+		})
+	}
+}
+
+func TestLoadCSV_UnpivotUnknownColumn(t *testing.T) {
+	sqlite := newTestDB(t)
+	descriptor := newTestDescriptor(writeTestCSV(t, "station,day1\nA,1\n"))
+	descriptor.Unpivot = &Unpivot{IDColumns: []string{"city"}}
+
+	_, err := sqlite.LoadCSV("readings", descriptor)
+	assert.EqualError(t, err, "unpivot: column `city` is not found")
+}
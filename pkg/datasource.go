@@ -21,7 +21,7 @@ import (
 type CSVFileDatasource struct {
 	plugin.NetRPCUnsupportedPlugin
 	MainLogger hclog.Logger
-	Db csv.DB
+	Datasets *csv.DatasetCache
 }
 
 func (ds *CSVFileDatasource) Query(ctx context.Context, req *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
@@ -93,30 +93,28 @@ func (ds *CSVFileDatasource) testConnectionLocal(dsModel *model.Datasource) erro
 }
 
 func (ds *CSVFileDatasource) testConnectionSftp(dsModel *model.Datasource) error {
-	return sftp.Test(sftp.ConnectionConfig{
-		Host:          dsModel.SftpHost,
-		Port:          dsModel.SftpPort,
-		User:          dsModel.SftpUser,
-		Password:      dsModel.SftpPassword,
-		Timeout:       time.Second * 30, // TODO: Move to UI
-		IgnoreHostKey: dsModel.SftpIgnoreHostKey,
-	})
+	remoteFile, err := ds.getRemoteFile(dsModel)
+	if err != nil {
+		return err
+	}
+	_, _, err = remoteFile.Stat()
+	return err
 }
 
 func (ds *CSVFileDatasource) performQuery(dsModel *model.Datasource, queryModel *model.Query, scope *macro.Scope) *datasource.QueryResult {
 	csvFilename := dsModel.Filename
+	var csvSource csv.Source
 
 	if dsModel.AccessMode == model.AccessMode_SFTP {
-		ds.debugf("Going to download remote file `%s`", csvFilename)
-		downloadedFile, err := ds.getRemoteFile(csvFilename, dsModel)
+		remoteFile, err := ds.getRemoteFile(dsModel)
 		if err != nil {
 			return &datasource.QueryResult{
-				Error: fmt.Sprintf("Could not download CSV data file: %s", err.Error()),
+				Error: fmt.Sprintf("Could not read CSV data file: %s", err.Error()),
 				RefId: queryModel.RefID,
 			}
 		}
-		ds.debugf("File has been downloaded %s->%s", csvFilename, downloadedFile)
-		csvFilename = downloadedFile
+		ds.debugf("Going to stream remote file `%s` from %s:%s", remoteFile.Path, remoteFile.Conn.Host, remoteFile.Conn.Port)
+		csvSource = remoteFile
 	}
 
 	tableColumns := make([]csv.Column, 0)
@@ -127,8 +125,9 @@ func (ds *CSVFileDatasource) performQuery(dsModel *model.Datasource, queryModel
 		})
 	}
 
-	err := ds.Db.LoadCSV(dsModel.Name, &csv.FileDescriptor{
+	dataset, release, err := ds.Datasets.Acquire(dsModel.Name, &csv.FileDescriptor{
 		Filename:         csvFilename,
+		Source:           csvSource,
 		Delimiter:        rune(dsModel.CsvDelimiter[0]),
 		Comment:          rune(dsModel.CsvComment[0]),
 		TrimLeadingSpace: dsModel.CsvTrimLeadingSpace,
@@ -141,6 +140,7 @@ func (ds *CSVFileDatasource) performQuery(dsModel *model.Datasource, queryModel
 			RefId: queryModel.RefID,
 		}
 	}
+	defer release()
 
 	interpolatedQuery, err := macro.Interpolate(queryModel.Query, scope)
 	if err != nil {
@@ -150,7 +150,7 @@ func (ds *CSVFileDatasource) performQuery(dsModel *model.Datasource, queryModel
 		}
 	}
 
-	result, err := ds.Db.Query(interpolatedQuery)
+	result, err := dataset.Query(interpolatedQuery)
 	if err != nil {
 		return &datasource.QueryResult{
 			Error: fmt.Sprintf("Query failed: %s", err.Error()),
@@ -204,20 +204,37 @@ func (ds *CSVFileDatasource) toGfTable(refId string, result *csv.QueryResult) *d
 	}
 }
 
-func (ds *CSVFileDatasource) getRemoteFile(file string, dsModel *model.Datasource) (string, error) {
-	downloadedFile, err := sftp.GetFile(sftp.ConnectionConfig{
-		Host:          dsModel.SftpHost,
-		Port:          dsModel.SftpPort,
-		User:          dsModel.SftpUser,
-		Password:      dsModel.SftpPassword,
-		Timeout:       time.Second * 30,
-		IgnoreHostKey: dsModel.SftpIgnoreHostKey,
-	}, file, dsModel.SftpWorkingDir)
-	if err != nil {
-		ds.logError(fmt.Sprintf("Could not download CSV data file: %s", err.Error()))
-		return "", err
+func (ds *CSVFileDatasource) getRemoteFile(dsModel *model.Datasource) (*sftp.File, error) {
+	remoteFile := &sftp.File{
+		Conn: sftp.ConnectionConfig{
+			Host:          dsModel.SftpHost,
+			Port:          dsModel.SftpPort,
+			User:          dsModel.SftpUser,
+			Password:      dsModel.SftpPassword,
+			PrivateKey:    dsModel.SftpPrivateKey,
+			Timeout:       time.Second * 30, // TODO: Move to UI
+			IgnoreHostKey: dsModel.SftpIgnoreHostKey,
+			HostKey:       dsModel.SftpHostKey,
+		},
+		Path: dsModel.Filename,
+	}
+
+	// sftp://user@host:port/path overrides the connection settings
+	if sftp.IsURL(dsModel.Filename) {
+		urlConn, path, err := sftp.ParseURL(dsModel.Filename)
+		if err != nil {
+			ds.logError(fmt.Sprintf("Could not parse SFTP URL: %s", err.Error()))
+			return nil, err
+		}
+		remoteFile.Conn.Host = urlConn.Host
+		remoteFile.Conn.Port = urlConn.Port
+		if len(urlConn.User) > 0 {
+			remoteFile.Conn.User = urlConn.User
+		}
+		remoteFile.Path = path
 	}
-	return downloadedFile, nil
+
+	return remoteFile, nil
 }
 
 func (ds *CSVFileDatasource) resultWithError(result *datasource.DatasourceResponse, errorMessage string) {
@@ -7,6 +7,8 @@ import (
 	"github.com/paveldanilin/grafana-csv-plugin/pkg/csv"
 	"github.com/paveldanilin/grafana-csv-plugin/pkg/util"
 	"io"
+	"math"
+	"strings"
 	"time"
 )
 
@@ -210,3 +212,209 @@ func toRowValue(value interface{}) *datasource.RowValue {
 	return nil
 }
 
+// Field is a typed column of a query result.
+// Values is one of []*int64, []*float64, []*time.Time, []*string, []*bool, a NULL is a nil pointer.
+// The plugin is built on grafana-plugin-model, grafana-plugin-sdk-go is not its dependency, so data.Field can't be built here.
+// The vectors have the types data.NewField accepts: data.NewField(field.Name, nil, field.Values).
+type Field struct {
+	Name   string
+	Values interface{}
+}
+
+// Querier runs a query, e.g. csv.DB or csv.Dataset
+type Querier interface {
+	Query(sql string) (*csv.QueryResult, error)
+}
+
+// Runs the query and converts its result into typed fields, see ToFields
+func QueryFields(db Querier, sql string) ([]*Field, error) {
+	result, err := db.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Release()
+	return ToFields(result)
+}
+
+// Converts the query result into typed fields, a field type comes from the SQLite column type.
+// Columns without a declared type (expressions, aggregates) are typed by their first non NULL value.
+// A column holding a value of another type (SQLite does not enforce column types) becomes a string field.
+func ToFields(result *csv.QueryResult) ([]*Field, error) {
+	columnNames, err := result.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	columnTypes, err := result.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]interface{}, 0)
+	for {
+		row, err := result.Next()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF {
+			break
+		}
+		// Next() reuses the slice
+		rows = append(rows, append([]interface{}{}, row...))
+	}
+
+	fields := make([]*Field, 0)
+	for i, columnName := range columnNames {
+		kind := fieldKindOf(columnTypes[i].DatabaseTypeName())
+		if kind == fieldKindUnknown {
+			kind = fieldKindOfValues(rows, i)
+		}
+		values, ok := toFieldValues(kind, rows, i)
+		if !ok {
+			values, _ = toFieldValues(fieldKindString, rows, i)
+		}
+		fields = append(fields, &Field{
+			Name:   columnName,
+			Values: values,
+		})
+	}
+
+	return fields, nil
+}
+
+type fieldKind int
+
+const (
+	fieldKindUnknown fieldKind = iota
+	fieldKindInt64
+	fieldKindFloat64
+	fieldKindTime
+	fieldKindString
+	fieldKindBool
+)
+
+func fieldKindOf(databaseTypeName string) fieldKind {
+	switch strings.ToUpper(databaseTypeName) {
+	case "INTEGER", "TIMESTAMP":
+		return fieldKindInt64
+	case "REAL":
+		return fieldKindFloat64
+	case "DATE", "DATETIME":
+		return fieldKindTime
+	case "TEXT":
+		return fieldKindString
+	case "BOOLEAN":
+		return fieldKindBool
+	}
+	return fieldKindUnknown
+}
+
+func fieldKindOfValues(rows [][]interface{}, columnIndex int) fieldKind {
+	for _, row := range rows {
+		switch row[columnIndex].(type) {
+		case int64:
+			return fieldKindInt64
+		case float64:
+			return fieldKindFloat64
+		case time.Time:
+			return fieldKindTime
+		case bool:
+			return fieldKindBool
+		case nil:
+			continue
+		}
+		return fieldKindString
+	}
+	return fieldKindString
+}
+
+// Returns false if a value can't be converted to the kind without a loss
+func toFieldValues(kind fieldKind, rows [][]interface{}, columnIndex int) (interface{}, bool) {
+	switch kind {
+	case fieldKindInt64:
+		values := make([]*int64, len(rows))
+		for i, row := range rows {
+			switch v := row[columnIndex].(type) {
+			case nil:
+				continue
+			case int64:
+				values[i] = &v
+			case float64:
+				if v != math.Trunc(v) {
+					return nil, false
+				}
+				iv := int64(v)
+				values[i] = &iv
+			case time.Time:
+				// Timestamps are Unix seconds, the driver returns them as time
+				iv := v.Unix()
+				values[i] = &iv
+			default:
+				return nil, false
+			}
+		}
+		return values, true
+	case fieldKindFloat64:
+		values := make([]*float64, len(rows))
+		for i, row := range rows {
+			if row[columnIndex] == nil {
+				continue
+			}
+			v, err := util.ToFloat64(row[columnIndex])
+			if err != nil {
+				return nil, false
+			}
+			values[i] = &v
+		}
+		return values, true
+	case fieldKindTime:
+		values := make([]*time.Time, len(rows))
+		for i, row := range rows {
+			switch v := row[columnIndex].(type) {
+			case nil:
+				continue
+			case time.Time:
+				values[i] = &v
+			case string:
+				t, err := dateparse.ParseAny(v)
+				if err != nil {
+					return nil, false
+				}
+				values[i] = &t
+			default:
+				return nil, false
+			}
+		}
+		return values, true
+	case fieldKindBool:
+		values := make([]*bool, len(rows))
+		for i, row := range rows {
+			switch v := row[columnIndex].(type) {
+			case nil:
+				continue
+			case bool:
+				values[i] = &v
+			case int64:
+				b := v != 0
+				values[i] = &b
+			default:
+				return nil, false
+			}
+		}
+		return values, true
+	}
+	values := make([]*string, len(rows))
+	for i, row := range rows {
+		switch v := row[columnIndex].(type) {
+		case nil:
+			continue
+		case []byte:
+			s := string(v)
+			values[i] = &s
+		default:
+			s := fmt.Sprint(v)
+			values[i] = &s
+		}
+	}
+	return values, true
+}
@@ -0,0 +1,57 @@
+package grafana
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paveldanilin/grafana-csv-plugin/pkg/csv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToFields(t *testing.T) {
+	file, err := ioutil.TempFile("", "grafana_test_*.csv")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("id,price,sold_at,name\n1,1.5,2020-05-01,apple\n2,2.5,,\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	db, err := csv.NewDB(1, 0, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Init())
+	_, err = db.LoadCSV("fields", &csv.FileDescriptor{Filename: file.Name(), Delimiter: ',', Comment: '#'})
+	require.NoError(t, err)
+
+	fields, err := QueryFields(db, "SELECT id, price, sold_at, name, price * 2 AS doubled, CASE id WHEN 1 THEN 1 ELSE 'n/a' END AS mixed FROM fields ORDER BY id")
+	require.NoError(t, err)
+	require.Len(t, fields, 6)
+
+	ids := fields[0].Values.([]*int64)
+	assert.Equal(t, int64(1), *ids[0])
+	assert.Equal(t, int64(2), *ids[1])
+
+	prices := fields[1].Values.([]*float64)
+	assert.Equal(t, 1.5, *prices[0])
+
+	soldAt := fields[2].Values.([]*time.Time)
+	assert.Equal(t, "2020-05-01", soldAt[0].Format("2006-01-02"))
+	assert.Nil(t, soldAt[1])
+
+	names := fields[3].Values.([]*string)
+	assert.Equal(t, "apple", *names[0])
+
+	doubled := fields[4].Values.([]*float64)
+	assert.Equal(t, "doubled", fields[4].Name)
+	assert.Equal(t, 5.0, *doubled[1])
+
+	// The text value is kept, the column becomes a string field
+	mixed := fields[5].Values.([]*string)
+	assert.Equal(t, "1", *mixed[0])
+	assert.Equal(t, "n/a", *mixed[1])
+
+	_, err = QueryFields(db, "SELECT missing FROM fields")
+	assert.Error(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/grafana/grafana-plugin-model/go/datasource"
+	"strings"
 )
 
 const (
@@ -33,8 +34,9 @@ type Datasource struct {
 	SftpPort		string	`json:"sftpPort,omitempty"`
 	SftpUser		string	`json:"sftUser,omitempty"`
 	SftpPassword		string	`json:"sftPassword,omitempty"`
-	SftpWorkingDir		string	`json:"sftpWorkingDir"`		// Local working dir
+	SftpPrivateKey		string	`json:"-"`
 	SftpIgnoreHostKey	bool	`json:"sftpIgnoreHostKey"`
+	SftpHostKey		string	`json:"sftpHostKey,omitempty"`
 
 	Columns			[]struct {
 		Name	string	`json:"name"`
@@ -54,6 +56,7 @@ func CreateDatasourceFrom(req datasource.DatasourceRequest) (*Datasource, error)
 	model.Name = req.Datasource.Name
 	model.Type = req.Datasource.Type
 	model.SftpPassword = req.Datasource.DecryptedSecureJsonData["sftpPassword"]
+	model.SftpPrivateKey = req.Datasource.DecryptedSecureJsonData["sftpPrivateKey"]
 
 	if len(model.CsvDelimiter) == 0 {
 		model.CsvDelimiter = ","
@@ -98,11 +101,12 @@ func validateSftpDatasourceModel(model *Datasource) error {
 	if len(model.Filename) == 0 {
 		return errors.New("the path to the CSV file is not defined")
 	}
+	// sftp://user@host:port/path defines the connection itself
+	if strings.HasPrefix(model.Filename, "sftp://") {
+		return nil
+	}
 	if len(model.SftpHost) == 0 {
 		return errors.New("SFTP hot is not defined")
 	}
-	if len(model.SftpWorkingDir) == 0 {
-		return errors.New("working dir is not defined")
-	}
 	return nil
 }
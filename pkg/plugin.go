@@ -17,6 +17,9 @@ const (
 	ExitMessage    = "CSV plugin has been stopped"
 	Name           = "grafana_csv_plugin"
 	Version        = "2.0.0"
+	// Limits of the loaded CSV files kept in memory
+	MaxCachedDatasets = 16
+	MaxCachedRows     = 5000000
 )
 
 func main() {
@@ -27,15 +30,11 @@ func main() {
 	})
 	logger.Info(WelcomeMessage, "version", Version)
 
-	csvDb, err := csv.NewDB(100, 0, logger)
-	if err != nil {
-		logger.Error("Could not create CSV database", "error", err.Error())
-		return
-	}
-	if err := csvDb.Init(); err != nil {
-		logger.Error("Could not init CSV database", "error", err.Error())
-		return
-	}
+	// Loaded CSV files are reused between queries
+	datasets := csv.NewDatasetCache(MaxCachedDatasets, MaxCachedRows, logger)
+	// A changed file never blocks queries, they get the previous version until it is reloaded
+	datasets.RefreshInBackground = true
+	defer datasets.Close()
 
 	macro.Register(time_filter.MacroName, time_filter.Processor)
 	macro.Register(unix_epoch_from.MacroName, unix_epoch_from.Processor)
@@ -53,7 +52,7 @@ func main() {
 		Plugins: map[string]plugin.Plugin{
 		      Name: &datasource.DatasourcePluginImpl{Plugin: &CSVFileDatasource{
 		      		MainLogger: logger,
-		      		Db: csvDb,
+		      		Datasets: datasets,
 		      }},
 		},
 
@@ -7,67 +7,163 @@ import (
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 type ConnectionConfig struct {
-	Host          string
-	Port          string
-	User          string
-	Password      string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	// PEM encoded private key, used together with the password if both are set
+	PrivateKey    string
 	Timeout       time.Duration
 	IgnoreHostKey bool
+	// Expected host key in the authorized_keys format, if empty the key is looked up in ~/.ssh/known_hosts
+	HostKey string
 }
 
-func Test(conn ConnectionConfig) error {
-	c, err := newConnection(conn)
-	if err != nil {
-		return err
-	}
-	_ = c.Close()
-	return nil
+// File is a remote file streamed over SFTP, it implements csv.Source
+type File struct {
+	Conn ConnectionConfig
+	Path string
 }
 
-func GetFile(conn ConnectionConfig, fileName string, targetDir string) (string, error) {
-	c, err := newConnection(conn)
+// Opens the remote file, closing the returned reader also closes the SFTP session and the SSH connection
+func (f *File) Open() (io.ReadCloser, error) {
+	c, client, err := newClient(f.Conn)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer c.Close()
 
-	client, err := sftp.NewClient(c)
+	remoteFile, err := client.Open(f.Path)
 	if err != nil {
-		return "", err
+		_ = client.Close()
+		_ = c.Close()
+		return nil, errors.New(fmt.Sprintf("could not open remote file `%s`: %s", f.Path, err.Error()))
 	}
-	defer client.Close()
 
-	localFilename := filepath.Base(fileName)
+	return &remoteReader{File: remoteFile, client: client, conn: c}, nil
+}
+
+// Stat is called on every query to detect changes, each call would cost an SSH handshake,
+// hence the result is reused for a while
+const statTTL = 10 * time.Second
+
+var stats = &statCache{entries: make(map[string]statEntry)}
+
+type statEntry struct {
+	size     int64
+	modTime  int64
+	cachedAt time.Time
+}
+
+// Sizes and modification times of remote files by connection and path, failures are not cached
+type statCache struct {
+	mu      sync.Mutex
+	entries map[string]statEntry
+}
 
-	// Dest file
-	dstFile, err := os.Create(filepath.Join(targetDir, localFilename))
+func (c *statCache) get(key string, now time.Time, stat func() (int64, int64, error)) (int64, int64, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Sub(entry.cachedAt) < statTTL {
+		return entry.size, entry.modTime, nil
+	}
+
+	size, modTime, err := stat()
 	if err != nil {
-		return "", err
+		return 0, 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Expired entries of other files are dropped on the way, so the map does not grow with every file ever stat'ed
+	for k, e := range c.entries {
+		if now.Sub(e.cachedAt) >= statTTL {
+			delete(c.entries, k)
+		}
 	}
+	c.entries[key] = statEntry{size: size, modTime: modTime, cachedAt: now}
+	return size, modTime, nil
+}
+
+// Returns size and modification time (unix seconds) of the remote file, the result may be up to statTTL old
+func (f *File) Stat() (int64, int64, error) {
+	key := fmt.Sprintf("%s@%s:%s%s", f.Conn.User, f.Conn.Host, f.Conn.Port, f.Path)
+	return stats.get(key, time.Now(), f.stat)
+}
 
-	srcFile, err := client.Open(fileName)
+func (f *File) stat() (int64, int64, error) {
+	c, client, err := newClient(f.Conn)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
+	defer c.Close()
+	defer client.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
+	fileInfo, err := client.Stat(f.Path)
 	if err != nil {
-		return "", err
+		return 0, 0, errors.New(fmt.Sprintf("could not stat remote file `%s`: %s", f.Path, err.Error()))
 	}
+	return fileInfo.Size(), fileInfo.ModTime().Unix(), nil
+}
+
+type remoteReader struct {
+	*sftp.File
+	client *sftp.Client
+	conn   *ssh.Client
+}
+
+func (r *remoteReader) Close() error {
+	err := r.File.Close()
+	_ = r.client.Close()
+	_ = r.conn.Close()
+	return err
+}
 
-	err = dstFile.Sync()
+// Parses sftp://user@host:port/path, the port defaults to 22.
+// Credentials other than the user name are expected to come from secure settings, a URL with a password is rejected,
+// so it does not end up in logs and the datasource JSON.
+func ParseURL(rawURL string) (ConnectionConfig, string, error) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return "", err
+		return ConnectionConfig{}, "", err
+	}
+	// Checked first, the errors below quote the URL
+	if _, ok := u.User.Password(); ok {
+		return ConnectionConfig{}, "", errors.New("a password in the sftp:// URL is not allowed, set it in the secure settings of the datasource")
+	}
+	if u.Scheme != "sftp" {
+		return ConnectionConfig{}, "", errors.New(fmt.Sprintf("unexpected scheme `%s`, expected sftp", u.Scheme))
+	}
+	if len(u.Hostname()) == 0 {
+		return ConnectionConfig{}, "", errors.New(fmt.Sprintf("host is not defined in `%s`", rawURL))
+	}
+	if len(u.Path) == 0 || u.Path == "/" {
+		return ConnectionConfig{}, "", errors.New(fmt.Sprintf("file path is not defined in `%s`", rawURL))
 	}
 
-	return filepath.Join(targetDir, localFilename), nil
+	conn := ConnectionConfig{
+		Host: u.Hostname(),
+		Port: u.Port(),
+	}
+	if len(conn.Port) == 0 {
+		conn.Port = "22"
+	}
+	if u.User != nil {
+		conn.User = u.User.Username()
+	}
+	return conn, u.Path, nil
+}
+
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "sftp://")
 }
 
 func newConnection(conn ConnectionConfig) (*ssh.Client, error) {
@@ -78,34 +174,68 @@ func newConnection(conn ConnectionConfig) (*ssh.Client, error) {
 
 	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", conn.Host, conn.Port), config)
 	if err != nil {
-		return nil, err
+		return nil, errors.New(fmt.Sprintf("could not connect to %s:%s: %s", conn.Host, conn.Port, err.Error()))
 	}
 
 	return client, nil
 }
 
+func newClient(conn ConnectionConfig) (*ssh.Client, *sftp.Client, error) {
+	c, err := newConnection(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClient(c)
+	if err != nil {
+		_ = c.Close()
+		return nil, nil, errors.New(fmt.Sprintf("could not start SFTP session on %s:%s: %s", conn.Host, conn.Port, err.Error()))
+	}
+
+	return c, client, nil
+}
+
 func newShhClientConfig(conn ConnectionConfig) (*ssh.ClientConfig, error) {
 	hostKeyCallback := ssh.InsecureIgnoreHostKey()
 
 	if conn.IgnoreHostKey == false {
-		hostKey, err := getHostKey(conn.Host)
+		hostKey, err := getHostKey(conn)
 		if err != nil {
 			return nil, err
 		}
 		hostKeyCallback = ssh.FixedHostKey(*hostKey)
 	}
 
+	auth := make([]ssh.AuthMethod, 0)
+	if len(conn.PrivateKey) > 0 {
+		signer, err := ssh.ParsePrivateKey([]byte(conn.PrivateKey))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("could not parse private key: %s", err.Error()))
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(conn.Password) > 0 || len(auth) == 0 {
+		auth = append(auth, ssh.Password(conn.Password))
+	}
+
 	return &ssh.ClientConfig{
-		User:              conn.User,
-		Auth:              []ssh.AuthMethod{
-			ssh.Password(conn.Password),
-		},
+		User:            conn.User,
+		Auth:            auth,
 		HostKeyCallback: hostKeyCallback,
 		Timeout:         conn.Timeout,
 	}, nil
 }
 
-func getHostKey(host string) (*ssh.PublicKey, error) {
+func getHostKey(conn ConnectionConfig) (*ssh.PublicKey, error) {
+	if len(conn.HostKey) > 0 {
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(conn.HostKey))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("could not parse host key: %s", err.Error()))
+		}
+		return &hostKey, nil
+	}
+
+	host := conn.Host
 	// parse OpenSSH known_hosts file
 	// ssh or use ssh-keyscan to get initial key
 	file, err := os.Open(filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"))
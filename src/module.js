@@ -27,9 +27,10 @@ class FileConfigCtrl {
     this.current.jsonData.sftpPort = this.current.jsonData.sftpPort || '';
     this.current.jsonData.sftpUser = this.current.jsonData.sftpUser || '';
     this.current.jsonData.sftpIgnoreHostKey = this.current.jsonData.sftpIgnoreHostKey || false;
-    this.current.jsonData.sftpWorkingDir = this.current.jsonData.sftpWorkingDir || '';
+    this.current.jsonData.sftpHostKey = this.current.jsonData.sftpHostKey || '';
     this.current.secureJsonData = this.current.secureJsonData || {};
     this.current.secureJsonData.sftpPassword = this.current.secureJsonData.sftpPassword || null;
+    this.current.secureJsonData.sftpPrivateKey = this.current.secureJsonData.sftpPrivateKey || null;
     this.current.jsonData.columns = this.current.jsonData.columns || [];
 
     this.onPasswordReset = (event) => {
@@ -44,6 +45,13 @@ class FileConfigCtrl {
       this.current.secureJsonData =  this.current.secureJsonData || {};
       this.current.secureJsonData['sftpPassword'] = event.currentTarget.value;
     };
+
+    this.onPrivateKeyReset = (event) => {
+      event.preventDefault();
+      this.current.secureJsonFields['sftpPrivateKey'] = false;
+      this.current.secureJsonData = this.current.secureJsonData || {};
+      this.current.secureJsonData['sftpPrivateKey'] = '';
+    };
   }
 
   addColumn(evt) {